@@ -0,0 +1,224 @@
+// client is a client of the database server (see the -mode server flag) for Go programs.
+//
+// A Client keeps a pool of sessions in the JSON protocol of the server, so it may
+// be shared by goroutines. The sessions are opened when needed; a session broken
+// by the server or the network is replaced by a new one.
+package client
+
+import (
+	"context"
+	"errors"
+	"practice/internal/tcp"
+	"practice/internal/tui"
+	"practice/internal/user"
+	"sync"
+)
+
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrReadOnly     = errors.New("database is read-only")
+	ErrRateLimited  = errors.New("too many requests")
+	ErrConflict     = errors.New("user has been changed")
+	ErrClosed       = errors.New("client is closed")
+)
+
+// Error is the error returned by the server. It wraps one of the errors above
+// according to the code.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	switch e.Code {
+	case tui.CodeBadRequest, tui.CodeUnknownCommand:
+		return ErrBadRequest
+	case tui.CodeUnauthorized:
+		return ErrUnauthorized
+	case tui.CodeForbidden:
+		return ErrForbidden
+	case tui.CodeNotFound:
+		return ErrNotFound
+	case tui.CodeReadOnly:
+		return ErrReadOnly
+	case tui.CodeRateLimited:
+		return ErrRateLimited
+	case tui.CodeConflict:
+		return ErrConflict
+	}
+	return nil
+}
+
+// Client is a client of the server. It is safe for concurrent use.
+type Client struct {
+	addr string
+	cfg  config
+
+	mu     sync.Mutex
+	idle   []*tcp.Conn
+	closed bool
+}
+
+// New returns the client of the server at the address. It doesn't connect
+// until the first request.
+func New(addr string, opts ...Option) *Client {
+	return &Client{addr: addr, cfg: newConfig(opts)}
+}
+
+// ListUsers returns the users of the database.
+func (c *Client) ListUsers(ctx context.Context) (users []user.User, err error) {
+	err = c.do(ctx, true, func(ctx context.Context, conn *tcp.Conn) error {
+		users, err = conn.Users(ctx)
+		return err
+	})
+	return users, err
+}
+
+// FindByName returns the user with the name. If there is no such user,
+// the error wraps ErrNotFound.
+func (c *Client) FindByName(ctx context.Context, name string) (u user.User, err error) {
+	err = c.do(ctx, true, func(ctx context.Context, conn *tcp.Conn) error {
+		u, err = conn.FindByName(ctx, name)
+		return err
+	})
+	return u, err
+}
+
+// AvgAgePerBook returns the average age of the readers per book, the oldest first.
+func (c *Client) AvgAgePerBook(ctx context.Context) (books []user.AvgAgePerBook, err error) {
+	err = c.do(ctx, true, func(ctx context.Context, conn *tcp.Conn) error {
+		books, err = conn.AvgAgePerBook(ctx)
+		return err
+	})
+	return books, err
+}
+
+// AddUser adds the user and returns them with the ID given by the database.
+func (c *Client) AddUser(ctx context.Context, u user.User) (added user.User, err error) {
+	err = c.do(ctx, false, func(ctx context.Context, conn *tcp.Conn) error {
+		added, err = conn.Add(ctx, u)
+		return err
+	})
+	return added, err
+}
+
+// RemoveUser removes the user with the name. If there is no such user,
+// the error wraps ErrNotFound.
+func (c *Client) RemoveUser(ctx context.Context, name string) error {
+	return c.do(ctx, false, func(ctx context.Context, conn *tcp.Conn) error {
+		return conn.Remove(ctx, name)
+	})
+}
+
+// Close closes the idle sessions. The sessions in use are closed when
+// their requests end.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	idle := c.idle
+	c.idle, c.closed = nil, true
+	c.mu.Unlock()
+
+	var errs []error
+	for _, conn := range idle {
+		errs = append(errs, conn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// do runs the request on a session of the pool within the timeout. If the session
+// is broken, the idempotent request is retried on another one; the other request
+// is retried only if it hasn't been sent, as the server may have done it. The retry
+// on a session from the pool isn't counted, as the server may have closed it while
+// it was idle.
+func (c *Client) do(ctx context.Context, idempotent bool, req func(context.Context, *tcp.Conn) error) error {
+	if c.cfg.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.timeout)
+		defer cancel()
+	}
+
+	retries := c.cfg.retries
+	for {
+		conn, reused, err := c.get(ctx)
+		if err != nil {
+			return err
+		}
+		err = req(ctx, conn)
+		var e *tui.Error
+		if err == nil || errors.As(err, &e) {
+			// The session is fine.
+			c.put(conn)
+			if e != nil {
+				return &Error{Code: e.Code, Message: e.Message}
+			}
+			return nil
+		}
+
+		conn.Close()
+		switch {
+		case ctx.Err() != nil, !idempotent && !errors.Is(err, tcp.ErrNotSent):
+			return err
+		case reused:
+		case retries > 0:
+			retries--
+		default:
+			return err
+		}
+	}
+}
+
+// get returns an idle session or opens a new one. It reports whether
+// the session came from the pool. The idle sessions the server has closed
+// are dropped.
+func (c *Client) get(ctx context.Context) (conn *tcp.Conn, reused bool, err error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, false, ErrClosed
+	}
+	for n := len(c.idle); n > 0; n = len(c.idle) {
+		conn = c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		if !conn.Closed() {
+			c.mu.Unlock()
+			return conn, true, nil
+		}
+		conn.Close()
+	}
+	c.mu.Unlock()
+
+	if conn, err = tcp.Dial(ctx, c.addr, c.cfg.tcp...); err != nil {
+		return nil, false, err
+	}
+	if conn.NeedsLogin() {
+		if _, err = conn.Login(ctx, c.cfg.name, c.cfg.password); err != nil {
+			conn.Close()
+			var e *tui.Error
+			if errors.As(err, &e) {
+				err = &Error{Code: e.Code, Message: e.Message}
+			}
+			return nil, false, err
+		}
+	}
+	return conn, false, nil
+}
+
+// put returns the session to the pool, or closes it if the pool is full.
+func (c *Client) put(conn *tcp.Conn) {
+	c.mu.Lock()
+	if !c.closed && len(c.idle) < c.cfg.maxIdle {
+		c.idle = append(c.idle, conn)
+		conn = nil
+	}
+	c.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
@@ -0,0 +1,318 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"practice/internal/auth"
+	"practice/internal/storage"
+	"practice/internal/tcp"
+	"practice/internal/user"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startServer serves the storage on the address until the returned function
+// is called or the test ends. An empty address means a random port.
+func startServer(t *testing.T, addr string, strg *storage.Storage, opts ...tcp.Option) (string, func()) {
+	t.Helper()
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		opts = append([]tcp.Option{tcp.WithDrainTimeout(10 * time.Millisecond)}, opts...)
+		tcp.Serve(ctx, listener, strg, opts...)
+	}()
+	stop := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return listener.Addr().String(), stop
+}
+
+func newStorage(t *testing.T, users ...user.User) *storage.Storage {
+	t.Helper()
+	strg := storage.New(storage.NewMemory(users...))
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { strg.Close() })
+	return strg
+}
+
+func TestClient(t *testing.T) {
+	strg := newStorage(t, user.User{ID: 1, Name: "John Doe", Age: 40, Books: []string{"Dune"}})
+	addr, _ := startServer(t, "", strg)
+	c := New(addr)
+	defer c.Close()
+	ctx := context.Background()
+
+	ann := user.User{Name: "Ann", Age: 20, Active: true, Mass: 55, Books: []string{"Dune", "Emma"}}
+	added, err := c.AddUser(ctx, ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ann.ID = 2
+	if !reflect.DeepEqual(added, ann) {
+		t.Errorf("AddUser() = %+v, want %+v", added, ann)
+	}
+
+	got, err := c.FindByName(ctx, "Ann")
+	if err != nil || !reflect.DeepEqual(got, ann) {
+		t.Errorf("FindByName() = %+v, %v, want %+v", got, err, ann)
+	}
+	if _, err = c.FindByName(ctx, "Nobody"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindByName(Nobody) error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err = c.AddUser(ctx, user.User{}); !errors.Is(err, ErrBadRequest) {
+		t.Errorf("AddUser(no name) error = %v, want %v", err, ErrBadRequest)
+	}
+
+	books, err := c.AvgAgePerBook(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantBooks := []user.AvgAgePerBook{{BookTitle: "Dune", AvgAge: 30}, {BookTitle: "Emma", AvgAge: 20}}
+	if !reflect.DeepEqual(books, wantBooks) {
+		t.Errorf("AvgAgePerBook() = %+v, want %+v", books, wantBooks)
+	}
+
+	if err = c.RemoveUser(ctx, "John Doe"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.RemoveUser(ctx, "John Doe"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RemoveUser(removed) error = %v, want %v", err, ErrNotFound)
+	}
+	users, err := c.ListUsers(ctx)
+	if err != nil || !reflect.DeepEqual(users, []user.User{ann}) {
+		t.Errorf("ListUsers() = %+v, %v, want %+v", users, err, []user.User{ann})
+	}
+
+	c.Close()
+	if _, err = c.ListUsers(ctx); err != ErrClosed {
+		t.Errorf("ListUsers() after Close error = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestClient_Pool(t *testing.T) {
+	strg := newStorage(t)
+	addr, _ := startServer(t, "", strg)
+	c := New(addr, WithMaxIdle(3))
+	defer c.Close()
+
+	const clients = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := context.Background()
+			if _, err := c.AddUser(ctx, user.User{Name: string(rune('a' + i))}); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := c.ListUsers(ctx); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if n := len(strg.Users()); n != clients {
+		t.Errorf("got %d users, want %d", n, clients)
+	}
+	c.mu.Lock()
+	idle := len(c.idle)
+	c.mu.Unlock()
+	if idle == 0 || idle > 3 {
+		t.Errorf("got %d idle sessions, want 1 to 3", idle)
+	}
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	strg := newStorage(t, user.User{ID: 1, Name: "John Doe"})
+	addr, stop := startServer(t, "", strg)
+	c := New(addr)
+	defer c.Close()
+	ctx := context.Background()
+
+	if _, err := c.ListUsers(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// The restart closes the idle session of the client.
+	stop()
+	startServer(t, addr, strg)
+
+	if _, err := c.AddUser(ctx, user.User{Name: "Ann"}); err != nil {
+		t.Errorf("AddUser() after restart error = %v", err)
+	}
+	if users, err := c.ListUsers(ctx); err != nil || len(users) != 2 {
+		t.Errorf("ListUsers() after restart = %v, %v, want 2 users", users, err)
+	}
+}
+
+// startDroppingProxy forwards the connections to the server at the address,
+// but it closes the connection instead of returning the response to the first
+// request that contains drop.
+func startDroppingProxy(t *testing.T, addr, drop string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	var dropped atomic.Bool
+	go func() {
+		for {
+			client, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer client.Close()
+				server, err := net.Dial("tcp", addr)
+				if err != nil {
+					return
+				}
+				defer server.Close()
+				cr, sr := bufio.NewReader(client), bufio.NewReader(server)
+				for {
+					// The session is a request and the lines up to the JSON response.
+					req, err := cr.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if _, err = io.WriteString(server, req); err != nil {
+						return
+					}
+					for {
+						line, err := sr.ReadString('\n')
+						if err != nil {
+							return
+						}
+						if strings.HasPrefix(line, "{") && strings.Contains(req, drop) && dropped.CompareAndSwap(false, true) {
+							return
+						}
+						if _, err = io.WriteString(client, line); err != nil {
+							return
+						}
+						if strings.HasPrefix(line, "{") {
+							break
+						}
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestClient_LostResponse(t *testing.T) {
+	strg := newStorage(t)
+	addr, _ := startServer(t, "", strg)
+	c := New(startDroppingProxy(t, addr, `"cmd":"add"`))
+	defer c.Close()
+	ctx := context.Background()
+
+	// The session goes to the pool.
+	if _, err := c.ListUsers(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// The server adds the user, but the response is lost, so the add isn't repeated.
+	if _, err := c.AddUser(ctx, user.User{Name: "Ann"}); err == nil {
+		t.Error("AddUser() error = nil")
+	}
+	if users := strg.Users(); len(users) != 1 {
+		t.Errorf("users = %+v, want only Ann", users)
+	}
+	// The next requests go on a new session.
+	if users, err := c.ListUsers(ctx); err != nil || len(users) != 1 {
+		t.Errorf("ListUsers() = %+v, %v, want Ann", users, err)
+	}
+}
+
+func TestClient_Timeout(t *testing.T) {
+	// The server accepts the connections and never answers.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	c := New(listener.Addr().String(), WithTimeout(50*time.Millisecond))
+	defer c.Close()
+	start := time.Now()
+	if _, err = c.ListUsers(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ListUsers() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("ListUsers() took %v", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = New(listener.Addr().String()).ListUsers(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("ListUsers(canceled) error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestClient_Login(t *testing.T) {
+	users := auth.NewStore()
+	users.Set("admin", "secret", auth.RoleWrite)
+	users.Set("guest", "guest", auth.RoleRead)
+	strg := newStorage(t, user.User{ID: 1, Name: "John Doe"})
+	addr, _ := startServer(t, "", strg, tcp.WithUsers(users))
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		login     Option
+		listErr   error
+		removeErr error
+	}{
+		{"writer", WithLogin("admin", "secret"), nil, nil},
+		{"reader", WithLogin("guest", "guest"), nil, ErrForbidden},
+		{"wrong password", WithLogin("admin", "guest"), ErrUnauthorized, ErrUnauthorized},
+		{"no login", func(*config) {}, ErrUnauthorized, ErrUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(addr, tt.login)
+			defer c.Close()
+			if _, err := c.ListUsers(ctx); !errors.Is(err, tt.listErr) {
+				t.Errorf("ListUsers() error = %v, want %v", err, tt.listErr)
+			}
+			if err := c.RemoveUser(ctx, "Nobody"); tt.removeErr != nil && !errors.Is(err, tt.removeErr) {
+				t.Errorf("RemoveUser() error = %v, want %v", err, tt.removeErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"crypto/tls"
+	"practice/internal/tcp"
+	"time"
+)
+
+const (
+	stdTimeout = 10 * time.Second
+	stdMaxIdle = 2
+	stdRetries = 1
+)
+
+// config holds the settings of the client.
+type config struct {
+	tcp            []tcp.Option
+	name, password string
+	timeout        time.Duration
+	maxIdle        int
+	retries        int
+}
+
+// Option configures the client.
+type Option func(*config)
+
+func newConfig(opts []Option) config {
+	cfg := config{
+		timeout: stdTimeout,
+		maxIdle: stdMaxIdle,
+		retries: stdRetries,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithTLS makes the client connect with TLS with the configuration.
+// By default, the connections are plaintext.
+func WithTLS(cfg *tls.Config) Option {
+	return func(c *config) {
+		c.tcp = append(c.tcp, tcp.WithTLS(cfg))
+	}
+}
+
+// WithLogin sets the name and the password used if the server has the users.
+func WithLogin(name, password string) Option {
+	return func(c *config) {
+		c.name, c.password = name, password
+	}
+}
+
+// WithTimeout limits every request, including connecting to the server.
+// Zero means no limit except the context of the request. By default, it is 10 seconds.
+func WithTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.timeout = d
+	}
+}
+
+// WithMaxIdle sets how many idle sessions are kept for the next requests.
+// By default, it is 2.
+func WithMaxIdle(n int) Option {
+	return func(c *config) {
+		c.maxIdle = n
+	}
+}
+
+// WithRetries sets how many times the request is retried on a new session if
+// the session breaks. The request that changes the users is retried only if it
+// hasn't been sent. By default, it is 1.
+func WithRetries(n int) Option {
+	return func(c *config) {
+		c.retries = n
+	}
+}
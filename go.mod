@@ -2,4 +2,9 @@ module practice
 
 go 1.21
 
-require golang.org/x/exp v0.0.0-20230711153332-06a737ee72cb
+require (
+	golang.org/x/exp v0.0.0-20230711153332-06a737ee72cb
+	golang.org/x/term v0.15.0
+)
+
+require golang.org/x/sys v0.15.0 // indirect
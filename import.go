@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"practice/internal/importer"
+	"practice/internal/storage"
+	"practice/internal/user"
+)
+
+// runImport runs the import command that imports the users from the CSV or JSON
+// file into the database (see package importer). It prints what the import makes;
+// with -dry-run, nothing is imported.
+func runImport(args []string) (status int) {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	dbPath := flags.String("db", "datafiles/test.database", "path to the database `file`")
+	format := flags.String("format", "", "format of the file: csv or json; by default, the extension tells it")
+	dryRun := flags.Bool("dry-run", false, "only print what would be imported")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s import [flags] FILE\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Imports the users from the CSV or JSON file; the users with the same names are updated.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	path := flags.Arg(0)
+	if *format == "" {
+		var err error
+		if *format, err = importer.FormatOf(path); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			flags.Usage()
+			return exitUsage
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer f.Close()
+	records, err := importer.Read(f, *format)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+
+	if _, err = os.Stat(*dbPath); *dryRun && errors.Is(err, fs.ErrNotExist) {
+		// The dry run doesn't create the database.
+		importer.NewPlan(nil, records).WriteSummary(os.Stdout)
+		fmt.Println("Dry run: nothing is imported.")
+		return exitOK
+	}
+	strg, err := storage.NewStorage(storage.WithPath(*dbPath), storage.WithReadOnly(*dryRun))
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer func() {
+		if err := strg.Close(); err != nil && status == exitOK {
+			log.Println(err)
+			status = exitFailure
+		}
+	}()
+	err = strg.Load()
+	var corruption *user.CorruptionError
+	switch {
+	case errors.As(err, &corruption):
+		log.Println("Warning: damaged records are skipped:", err)
+	case err != nil:
+		log.Println(err)
+		return exitFailure
+	}
+
+	p := importer.NewPlan(strg.Users(), records)
+	if *dryRun {
+		p.WriteSummary(os.Stdout)
+		fmt.Println("Dry run: nothing is imported.")
+		return exitOK
+	}
+	if err = p.Apply(strg); err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	p.WriteResult(os.Stdout)
+	// The import is in the log already; the snapshot only compacts it.
+	if err = strg.SaveSnapshot(); err != nil {
+		log.Println("Warning: couldn't save the snapshot:", err)
+	}
+	return exitOK
+}
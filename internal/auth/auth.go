@@ -0,0 +1,220 @@
+// auth keeps the credentials of the users of the TCP interface.
+//
+// The credentials file has a line per user: "name:role:salt:hash", where the role
+// is "read" or "write", and the salt and the hash of the password are hex-encoded.
+// The empty lines and the lines starting with '#' are skipped.
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	saltSize = 16
+	// hashRounds is the number of the SHA-256 rounds that slow down guessing the passwords.
+	hashRounds = 10000
+)
+
+var (
+	ErrBadCredentials = errors.New("invalid name or password")
+	ErrInvalidName    = errors.New("name can't be empty or contain spaces and colons")
+)
+
+// Role defines what the user can do with the database.
+type Role int
+
+const (
+	RoleRead  Role = iota // show the users
+	RoleWrite             // change the users as well
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleRead:
+		return "read"
+	case RoleWrite:
+		return "write"
+	}
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+// ParseRole parses the role written as "read" or "write".
+func ParseRole(s string) (Role, error) {
+	switch s {
+	case "read":
+		return RoleRead, nil
+	case "write":
+		return RoleWrite, nil
+	}
+	return 0, fmt.Errorf("invalid role %q", s)
+}
+
+// credential is the salted hash of the password of the user.
+type credential struct {
+	role Role
+	salt []byte
+	hash []byte
+}
+
+// Store keeps the credentials of the users. It is safe for concurrent use.
+type Store struct {
+	mu    sync.RWMutex
+	creds map[string]credential
+}
+
+// NewStore returns an empty Store.
+func NewStore() *Store {
+	return &Store{creds: make(map[string]credential)}
+}
+
+// LoadFile reads the credentials file at the path.
+func LoadFile(path string) (*Store, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := NewStore()
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, c, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		s.creds[name] = c
+	}
+	return s, sc.Err()
+}
+
+func parseLine(line string) (name string, c credential, err error) {
+	fields := strings.Split(line, ":")
+	if len(fields) != 4 {
+		return "", c, errors.New("want name:role:salt:hash")
+	}
+	name = fields[0]
+	if !validName(name) {
+		return "", c, ErrInvalidName
+	}
+	if c.role, err = ParseRole(fields[1]); err != nil {
+		return "", c, err
+	}
+	if c.salt, err = hex.DecodeString(fields[2]); err != nil {
+		return "", c, fmt.Errorf("invalid salt: %w", err)
+	}
+	if c.hash, err = hex.DecodeString(fields[3]); err != nil || len(c.hash) != sha256.Size {
+		return "", c, errors.New("invalid hash")
+	}
+	return name, c, nil
+}
+
+// SaveFile writes the credentials to the file at the path, replacing it atomically.
+func (s *Store) SaveFile(path string, perms fs.FileMode) (err error) {
+	s.mu.RLock()
+	names := make([]string, 0, len(s.creds))
+	for name := range s.creds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	buf.WriteString("# name:role:salt:hash\n")
+	for _, name := range names {
+		c := s.creds[name]
+		fmt.Fprintf(&buf, "%s:%s:%x:%x\n", name, c.role, c.salt, c.hash)
+	}
+	s.mu.RUnlock()
+
+	// The temporary file is renamed, so it must be in the same directory.
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), perms); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Set sets the password and the role of the user, adding the user if needed.
+func (s *Store) Set(name, password string, role Role) error {
+	if !validName(name) {
+		return ErrInvalidName
+	}
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.creds[name] = credential{role: role, salt: salt, hash: hashPassword(password, salt)}
+	return nil
+}
+
+// Delete removes the user. It reports whether the user existed.
+func (s *Store) Delete(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.creds[name]
+	delete(s.creds, name)
+	return ok
+}
+
+// Authenticate returns the role of the user if the password is correct.
+// Otherwise, ErrBadCredentials is returned.
+func (s *Store) Authenticate(name, password string) (Role, error) {
+	s.mu.RLock()
+	c, ok := s.creds[name]
+	s.mu.RUnlock()
+	if !ok {
+		// Take the same time as for the existing user, so the names can't be guessed.
+		c = credential{salt: make([]byte, saltSize), hash: make([]byte, sha256.Size)}
+	}
+	hash := hashPassword(password, c.salt)
+	if subtle.ConstantTimeCompare(hash, c.hash) != 1 || !ok {
+		return 0, ErrBadCredentials
+	}
+	return c.role, nil
+}
+
+// hashPassword returns the salted hash of the password.
+func hashPassword(password string, salt []byte) []byte {
+	buf := make([]byte, 0, sha256.Size+len(salt)+len(password))
+	buf = append(append(buf, salt...), password...)
+	h := sha256.Sum256(buf)
+	for i := 1; i < hashRounds; i++ {
+		buf = append(append(append(buf[:0], h[:]...), salt...), password...)
+		h = sha256.Sum256(buf)
+	}
+	return h[:]
+}
+
+func validName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, ": \t\r\n")
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStore_Authenticate(t *testing.T) {
+	s := NewStore()
+	if err := s.Set("admin", "secret", RoleWrite); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("guest", "guest", RoleRead); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		want     Role
+		wantErr  error
+	}{
+		{"writer", "admin", "secret", RoleWrite, nil},
+		{"reader", "guest", "guest", RoleRead, nil},
+		{"wrong password", "admin", "guest", 0, ErrBadCredentials},
+		{"empty password", "admin", "", 0, ErrBadCredentials},
+		{"unknown user", "root", "secret", 0, ErrBadCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Authenticate(tt.user, tt.password)
+			if err != tt.wantErr || got != tt.want {
+				t.Errorf("Authenticate() = %v, %v, want %v, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStore_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	s := NewStore()
+	s.Set("admin", "secret", RoleWrite)
+	s.Set("guest", "guest", RoleRead)
+	s.Set("gone", "gone", RoleRead)
+	if !s.Delete("gone") {
+		t.Error("Delete() = false, want true")
+	}
+	if err := s.SaveFile(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role, err := loaded.Authenticate("admin", "secret"); err != nil || role != RoleWrite {
+		t.Errorf("Authenticate(admin) = %v, %v, want %v", role, err, RoleWrite)
+	}
+	if role, err := loaded.Authenticate("guest", "guest"); err != nil || role != RoleRead {
+		t.Errorf("Authenticate(guest) = %v, %v, want %v", role, err, RoleRead)
+	}
+	if _, err := loaded.Authenticate("gone", "gone"); err != ErrBadCredentials {
+		t.Errorf("Authenticate(gone) error = %v, want %v", err, ErrBadCredentials)
+	}
+}
+
+func TestLoadFile_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"fields", "admin:write:00\n"},
+		{"role", "admin:root:00:" + strings.Repeat("0", 64) + "\n"},
+		{"salt", "admin:write:xyz:" + strings.Repeat("0", 64) + "\n"},
+		{"hash", "admin:write:00:00\n"},
+		{"name", " :write:00:" + strings.Repeat("0", 64) + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "users")
+			if err := os.WriteFile(path, []byte("# comment\n\n"+tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadFile(path); err == nil {
+				t.Error("LoadFile() error = nil")
+			}
+		})
+	}
+}
+
+func TestStore_SetInvalidName(t *testing.T) {
+	for _, name := range []string{"", "a:b", "a b"} {
+		if err := NewStore().Set(name, "secret", RoleRead); err != ErrInvalidName {
+			t.Errorf("Set(%q) error = %v, want %v", name, err, ErrInvalidName)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+// httpapi implements the HTTP JSON API over the user database.
+//
+//	GET    /users           lists the users; ?q=age>30 lists the users matching
+//	                        the query (see user.ParseQuery), ?sort=age,-mass sorts
+//	                        them (see user.ParseOrder), ?limit=10&offset=20 pages them
+//	POST   /users           adds the user
+//	GET    /users/{name}    returns the user
+//	PUT    /users/{name}    replaces the user
+//	DELETE /users/{name}    removes the user
+//	GET    /books/avg-age   returns the average age of the readers per book
+//
+// The errors are returned as {"error": {"code": "...", "message": "..."}}.
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"practice/internal/storage"
+	"practice/internal/user"
+	"strings"
+)
+
+// maxBodySize limits the size of the request body.
+const maxBodySize = 1 << 20 // 1 MiB
+
+// withBooks returns the user with the empty list of the books instead of nil,
+// so the JSON has "books": [].
+func withBooks(u user.User) user.User {
+	if u.Books == nil {
+		u.Books = []string{}
+	}
+	return u
+}
+
+// fromRequest returns the user decoded from the request as saved in the database.
+func fromRequest(u user.User) user.User {
+	if len(u.Books) == 0 {
+		u.Books = nil // as decoded from the database
+	}
+	// Like in the prompt, so the mass is the same after the reload.
+	u.Mass = user.VerifyMass(u.Mass)
+	return u
+}
+
+// Error is the JSON representation of the error.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// The codes of the errors.
+const (
+	CodeBadRequest       = "bad_request"
+	CodeNotFound         = "not_found"
+	CodeConflict         = "conflict"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeReadOnly         = "read_only"
+	CodeInternal         = "internal"
+)
+
+// handler serves the API.
+type handler struct {
+	strg *storage.Storage
+}
+
+// NewHandler returns the handler of the API over the storage.
+func NewHandler(strg *storage.Storage) http.Handler {
+	h := &handler{strg: strg}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users", h.users)
+	mux.HandleFunc("/users/", h.user)
+	mux.HandleFunc("/books/avg-age", h.avgAge)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "no such endpoint")
+	})
+	return mux
+}
+
+// users serves /users.
+func (h *handler) users(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		users, err := listUsers(h.strg.Users(), r.URL.Query())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+			return
+		}
+		res := make([]user.User, len(users))
+		for i, u := range users {
+			res[i] = withBooks(u)
+		}
+		writeJSON(w, http.StatusOK, res)
+	case http.MethodPost:
+		var in user.User
+		if !readJSON(w, r, &in) || !validate(w, in) {
+			return
+		}
+		u := fromRequest(in)
+		u.ID = 0
+		if err := h.strg.Apply(storage.Swap{New: &u}); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		w.Header().Set("Location", "/users/"+url.PathEscape(u.Name))
+		writeJSON(w, http.StatusCreated, withBooks(u))
+	default:
+		notAllowed(w, http.MethodGet, http.MethodHead, http.MethodPost)
+	}
+}
+
+// listUsers returns the users selected by the parameters q, sort, limit and offset.
+func listUsers(users user.Slice, params url.Values) (user.Slice, error) {
+	var o user.Order
+	if sort := params.Get("sort"); sort != "" {
+		var err error
+		if o, err = user.ParseOrder(sort); err != nil {
+			return nil, err
+		}
+	}
+	var p user.Predicate
+	if q := params.Get("q"); q != "" {
+		var err error
+		if p, err = user.ParseQuery(q); err != nil {
+			return nil, err
+		}
+	}
+	page, err := user.ParsePage(params.Get("offset"), params.Get("limit"))
+	if err != nil {
+		return nil, err
+	}
+	return users.List(o, p, page), nil
+}
+
+// user serves /users/{name}.
+func (h *handler) user(w http.ResponseWriter, r *http.Request) {
+	// The name may contain the escaped slashes, so the escaped path is used.
+	name, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/users/"))
+	if err != nil || name == "" {
+		writeError(w, http.StatusNotFound, CodeNotFound, "no such endpoint")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+	default:
+		notAllowed(w, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete)
+		return
+	}
+
+	u, ok := h.find(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, CodeNotFound, fmt.Sprintf("user %q is not found", name))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		writeJSON(w, http.StatusOK, withBooks(u))
+	case http.MethodPut:
+		var in user.User
+		if !readJSON(w, r, &in) {
+			return
+		}
+		if in.Name == "" {
+			in.Name = u.Name
+		}
+		if !validate(w, in) {
+			return
+		}
+		updated := fromRequest(in)
+		updated.ID = u.ID
+		// The user is replaced only if nobody has changed them since they are found.
+		if err := h.strg.Apply(storage.Swap{Old: &u, New: &updated}); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, withBooks(updated))
+	case http.MethodDelete:
+		if err := h.strg.Delete(u.ID); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// avgAge serves /books/avg-age.
+func (h *handler) avgAge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		notAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	books := user.AvgAgeOfReadersPerBook(h.strg.Users())
+	books.SortByAge()
+	if books == nil {
+		books = user.AvgAgePerBookSlice{} // "[]" rather than "null"
+	}
+	writeJSON(w, http.StatusOK, books)
+}
+
+// find returns the user with the name.
+func (h *handler) find(name string) (user.User, bool) {
+	users := h.strg.Users()
+	i, ok := user.Slice(users).FindName(name)
+	if !ok {
+		return user.User{}, false
+	}
+	return users[i], true
+}
+
+// validate writes the error response if the user can't be saved.
+func validate(w http.ResponseWriter, u user.User) bool {
+	switch {
+	case strings.TrimSpace(u.Name) == "":
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "name is required")
+	case u.Mass < 0:
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "mass can't be negative")
+	default:
+		return true
+	}
+	return false
+}
+
+// readJSON decodes the request body into v. On failure, it writes the error response.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil && dec.More() {
+		err = errors.New("unexpected data after the JSON object")
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = errors.New("empty body")
+		}
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "invalid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("httpapi: failed to write response:", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, struct {
+		Error Error `json:"error"`
+	}{Error{Code: code, Message: message}})
+}
+
+// writeStorageError writes the response for the error of the storage.
+func writeStorageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		writeError(w, http.StatusNotFound, CodeNotFound, err.Error())
+	case errors.Is(err, storage.ErrNameTaken):
+		writeError(w, http.StatusConflict, CodeConflict, err.Error())
+	case errors.Is(err, storage.ErrConflict):
+		writeError(w, http.StatusConflict, CodeConflict, "user has been changed by another request; try again")
+	case errors.Is(err, storage.ErrReadOnly):
+		writeError(w, http.StatusForbidden, CodeReadOnly, err.Error())
+	case errors.Is(err, user.ErrRecordTooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, CodeBadRequest, err.Error())
+	default:
+		log.Println("httpapi:", err)
+		writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+	}
+}
+
+func notAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method is not allowed")
+}
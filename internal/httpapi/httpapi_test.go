@@ -0,0 +1,268 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"practice/internal/auth"
+	"practice/internal/storage"
+	"practice/internal/tui"
+	"practice/internal/user"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var testUsers = []user.User{
+	{ID: 1, Name: "John Doe", Age: 30, Active: true, Mass: 80, Books: []string{"Go", "C"}},
+	{ID: 2, Name: "Jane Doe", Age: 20, Mass: 60, Books: []string{"Go"}},
+	{ID: 3, Name: "Jake/Doe", Age: 40},
+}
+
+func newServer(t *testing.T, backend storage.Backend) (*httptest.Server, *storage.Storage) {
+	t.Helper()
+	strg := storage.New(backend)
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewHandler(strg))
+	t.Cleanup(func() {
+		srv.Close()
+		strg.Close()
+	})
+	return srv, strg
+}
+
+func do(t *testing.T, srv *httptest.Server, method, path, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		status   int
+		wantBody string // the JSON the response must be equal to
+		wantCode string // the code of the error
+	}{
+		{
+			name: "list", method: "GET", path: "/users", status: http.StatusOK,
+			wantBody: `[
+				{"id":1,"name":"John Doe","age":30,"active":true,"mass":80,"books":["Go","C"]},
+				{"id":2,"name":"Jane Doe","age":20,"active":false,"mass":60,"books":["Go"]},
+				{"id":3,"name":"Jake/Doe","age":40,"active":false,"mass":0,"books":[]}]`,
+		},
+		{
+			name: "query", method: "GET", path: "/users?q=" + url.QueryEscape(`age>=30 book~"go"`), status: http.StatusOK,
+			wantBody: `[{"id":1,"name":"John Doe","age":30,"active":true,"mass":80,"books":["Go","C"]}]`,
+		},
+		{name: "query no match", method: "GET", path: "/users?q=age>99", status: http.StatusOK, wantBody: `[]`},
+		{name: "query invalid", method: "GET", path: "/users?q=height>1", status: http.StatusBadRequest, wantCode: CodeBadRequest},
+		{
+			name: "sort", method: "GET", path: "/users?sort=-age&offset=1&limit=1", status: http.StatusOK,
+			wantBody: `[{"id":1,"name":"John Doe","age":30,"active":true,"mass":80,"books":["Go","C"]}]`,
+		},
+		{
+			name: "sort query", method: "GET", path: "/users?sort=name&q=doe&limit=2", status: http.StatusOK,
+			wantBody: `[
+				{"id":3,"name":"Jake/Doe","age":40,"active":false,"mass":0,"books":[]},
+				{"id":2,"name":"Jane Doe","age":20,"active":false,"mass":60,"books":["Go"]}]`,
+		},
+		{name: "sort invalid", method: "GET", path: "/users?sort=height", status: http.StatusBadRequest, wantCode: CodeBadRequest},
+		{name: "limit invalid", method: "GET", path: "/users?limit=-1", status: http.StatusBadRequest, wantCode: CodeBadRequest},
+		{name: "offset past end", method: "GET", path: "/users?offset=5", status: http.StatusOK, wantBody: `[]`},
+		{
+			name: "get", method: "GET", path: "/users/Jane%20Doe", status: http.StatusOK,
+			wantBody: `{"id":2,"name":"Jane Doe","age":20,"active":false,"mass":60,"books":["Go"]}`,
+		},
+		{
+			name: "get escaped slash", method: "GET", path: "/users/Jake%2FDoe", status: http.StatusOK,
+			wantBody: `{"id":3,"name":"Jake/Doe","age":40,"active":false,"mass":0,"books":[]}`,
+		},
+		{name: "get missing", method: "GET", path: "/users/Nobody", status: http.StatusNotFound, wantCode: CodeNotFound},
+		{
+			name: "add", method: "POST", path: "/users", status: http.StatusCreated,
+			body:     `{"name":"Jill Doe","age":25,"books":["Rust"]}`,
+			wantBody: `{"id":4,"name":"Jill Doe","age":25,"active":false,"mass":0,"books":["Rust"]}`,
+		},
+		{
+			name: "add mass in quintals", method: "POST", path: "/users", status: http.StatusCreated,
+			body:     `{"name":"Jill Doe","mass":0.5}`,
+			wantBody: `{"id":4,"name":"Jill Doe","age":0,"active":false,"mass":50,"books":[]}`,
+		},
+		{name: "add existing", method: "POST", path: "/users", body: `{"name":"John Doe"}`, status: http.StatusConflict, wantCode: CodeConflict},
+		{name: "add no name", method: "POST", path: "/users", body: `{"age":25}`, status: http.StatusBadRequest, wantCode: CodeBadRequest},
+		{name: "add negative mass", method: "POST", path: "/users", body: `{"name":"X","mass":-1}`, status: http.StatusBadRequest, wantCode: CodeBadRequest},
+		{name: "add age overflow", method: "POST", path: "/users", body: `{"name":"X","age":300}`, status: http.StatusBadRequest, wantCode: CodeBadRequest},
+		{name: "add unknown field", method: "POST", path: "/users", body: `{"name":"X","height":1}`, status: http.StatusBadRequest, wantCode: CodeBadRequest},
+		{name: "add empty body", method: "POST", path: "/users", status: http.StatusBadRequest, wantCode: CodeBadRequest},
+		{
+			name: "update", method: "PUT", path: "/users/Jane%20Doe", status: http.StatusOK,
+			body:     `{"age":21,"active":true,"books":["Go","Rust"]}`,
+			wantBody: `{"id":2,"name":"Jane Doe","age":21,"active":true,"mass":0,"books":["Go","Rust"]}`,
+		},
+		{
+			name: "rename", method: "PUT", path: "/users/Jane%20Doe", status: http.StatusOK,
+			body:     `{"name":"Jane Roe"}`,
+			wantBody: `{"id":2,"name":"Jane Roe","age":0,"active":false,"mass":0,"books":[]}`,
+		},
+		{name: "rename to existing", method: "PUT", path: "/users/Jane%20Doe", body: `{"name":"John Doe"}`, status: http.StatusConflict, wantCode: CodeConflict},
+		{name: "update missing", method: "PUT", path: "/users/Nobody", body: `{}`, status: http.StatusNotFound, wantCode: CodeNotFound},
+		{name: "delete", method: "DELETE", path: "/users/John%20Doe", status: http.StatusNoContent},
+		{name: "delete missing", method: "DELETE", path: "/users/Nobody", status: http.StatusNotFound, wantCode: CodeNotFound},
+		{
+			name: "avg age", method: "GET", path: "/books/avg-age", status: http.StatusOK,
+			wantBody: `[{"book":"C","avg_age":30},{"book":"Go","avg_age":25}]`,
+		},
+		{name: "method", method: "PATCH", path: "/users", status: http.StatusMethodNotAllowed, wantCode: CodeMethodNotAllowed},
+		{name: "user method", method: "POST", path: "/users/John%20Doe", status: http.StatusMethodNotAllowed, wantCode: CodeMethodNotAllowed},
+		{name: "unknown path", method: "GET", path: "/books", status: http.StatusNotFound, wantCode: CodeNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := newServer(t, storage.NewMemory(testUsers...))
+			res := do(t, srv, tt.method, tt.path, tt.body)
+			if res.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", res.StatusCode, tt.status)
+			}
+			if tt.status == http.StatusNoContent {
+				return
+			}
+			if ct := res.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+
+			var got any
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantCode != "" {
+				e, _ := got.(map[string]any)["error"].(map[string]any)
+				if e["code"] != tt.wantCode || e["message"] == "" {
+					t.Errorf("error = %v, want code %q", got, tt.wantCode)
+				}
+				return
+			}
+			var want any
+			if err := json.Unmarshal([]byte(tt.wantBody), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("body = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestHandler_Changes(t *testing.T) {
+	srv, strg := newServer(t, storage.NewMemory(testUsers...))
+
+	do(t, srv, "POST", "/users", `{"name":"Jill Doe","age":25}`)
+	do(t, srv, "PUT", "/users/Jane%20Doe", `{"age":21}`)
+	do(t, srv, "DELETE", "/users/John%20Doe", "")
+
+	want := []user.User{
+		{ID: 2, Name: "Jane Doe", Age: 21},
+		{ID: 3, Name: "Jake/Doe", Age: 40},
+		{ID: 4, Name: "Jill Doe", Age: 25},
+	}
+	if got := strg.Users(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Users() = %+v, want %+v", got, want)
+	}
+}
+
+func TestHandler_ConcurrentAdd(t *testing.T) {
+	srv, strg := newServer(t, storage.NewMemory())
+
+	const requests = 20
+	var wg sync.WaitGroup
+	statuses := make(chan int, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := srv.Client().Post(srv.URL+"/users", "application/json", strings.NewReader(`{"name":"Ann"}`))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			res.Body.Close()
+			statuses <- res.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	created := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("status = %d, want %d or %d", status, http.StatusCreated, http.StatusConflict)
+		}
+	}
+	if n := len(strg.Users()); created != 1 || n != 1 {
+		t.Errorf("%d users created, %d stored, want 1", created, n)
+	}
+}
+
+func TestHandler_NameTakenByPrompt(t *testing.T) {
+	srv, strg := newServer(t, storage.NewMemory())
+
+	var out bytes.Buffer
+	if err := tui.RunScript(&out, strings.NewReader("add name=Ann\n"), strg, auth.RoleWrite); err != nil {
+		t.Fatalf("RunScript() error = %v, output %q", err, out.String())
+	}
+	if res := do(t, srv, "POST", "/users", `{"name":"Ann"}`); res.StatusCode != http.StatusConflict {
+		t.Errorf("POST of the name added by the prompt status = %d, want %d", res.StatusCode, http.StatusConflict)
+	}
+	if res := do(t, srv, "POST", "/users", `{"name":"Bob"}`); res.StatusCode != http.StatusCreated {
+		t.Fatalf("POST status = %d, want %d", res.StatusCode, http.StatusCreated)
+	}
+	out.Reset()
+	err := tui.RunScript(&out, strings.NewReader("add name=Bob\n"), strg, auth.RoleWrite)
+	if !errors.Is(err, tui.ErrScriptFailed) || !strings.Contains(out.String(), "already exists") {
+		t.Errorf("RunScript() of the name added by POST = %v, output %q, want it to fail", err, out.String())
+	}
+	if n := len(strg.Users()); n != 2 {
+		t.Errorf("%d users stored, want 2", n)
+	}
+}
+
+func TestHandler_ReadOnly(t *testing.T) {
+	srv, _ := newServer(t, readOnlyBackend{storage.NewMemory(testUsers...)})
+
+	res := do(t, srv, "DELETE", "/users/John%20Doe", "")
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", res.StatusCode, http.StatusForbidden)
+	}
+	res = do(t, srv, "GET", "/users/John%20Doe", "")
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+}
+
+// readOnlyBackend rejects the changes like the read-only database.
+type readOnlyBackend struct {
+	*storage.MemoryBackend
+}
+
+func (readOnlyBackend) Append([]storage.Op) error { return storage.ErrReadOnly }
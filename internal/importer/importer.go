@@ -0,0 +1,342 @@
+// importer imports the users from CSV and JSON files.
+//
+// A CSV file starts with the header naming the columns: name, age, active, mass
+// and books, in any order; only name is required, and the books are separated
+// by semicolons. A JSON file is an array of objects with the same fields, like
+// the users printed by the JSON protocol; the books are an array. The id column
+// or field is ignored. The fields are validated like in the prompt (see user.ParseAge).
+//
+// The user with the name of a record is updated: the fields the file gives replace
+// theirs, and the rest are kept. The other records add the users.
+package importer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"practice/internal/storage"
+	"practice/internal/user"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// The formats of the files.
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown format")
+	ErrInvalidFile   = errors.New("invalid file")
+)
+
+// columns are the fields of the users in the files.
+var columns = []string{"id", "name", "age", "active", "mass", "books"}
+
+// FormatOf returns the format of the file by its extension.
+func FormatOf(path string) (string, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".csv":
+		return FormatCSV, nil
+	case ".json":
+		return FormatJSON, nil
+	}
+	return "", fmt.Errorf("%w of %s: the extension must be .csv or .json", ErrUnknownFormat, path)
+}
+
+// Record is a user read from the file.
+type Record struct {
+	N    int // the number of the record in the file, from 1
+	User user.User
+	// Fields are the fields the file gives, like "age"; the user has zero values of the rest.
+	Fields []string
+	Err    error // why the record is rejected; nil if the user is valid
+}
+
+// Read reads the records of the file in the format. The invalid records are
+// returned with the errors; if the file can't be read as a whole, the error
+// wrapping ErrInvalidFile is returned.
+func Read(r io.Reader, format string) ([]Record, error) {
+	switch format {
+	case FormatCSV:
+		return readCSV(r)
+	case FormatJSON:
+		return readJSON(r)
+	}
+	return nil, fmt.Errorf("%w %q: want csv or json", ErrUnknownFormat, format)
+}
+
+func readCSV(r io.Reader) ([]Record, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+	for i, column := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+		if !slices.Contains(columns, header[i]) {
+			return nil, fmt.Errorf("%w: unknown column %q; the columns are: %s", ErrInvalidFile, column, strings.Join(columns, ", "))
+		}
+	}
+	if !slices.Contains(header, "name") {
+		return nil, fmt.Errorf("%w: no name column", ErrInvalidFile)
+	}
+
+	var records []Record
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		rec := Record{N: len(records) + 1}
+		if errors.Is(err, csv.ErrFieldCount) {
+			rec.Err = fmt.Errorf("%d fields, want %d", len(row), len(header))
+			records = append(records, rec)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+		}
+		fields := make(map[string]string, len(row))
+		for i, v := range row {
+			fields[header[i]] = v
+		}
+		rec.User, rec.Err = parseUser(fields, strings.Split(fields["books"], ";"))
+		rec.Fields = fieldNames(fields)
+		records = append(records, rec)
+	}
+}
+
+func readJSON(r io.Reader) ([]Record, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var objects []any
+	if err := dec.Decode(&objects); err != nil {
+		return nil, fmt.Errorf("%w: want an array of the users: %v", ErrInvalidFile, err)
+	}
+	records := make([]Record, len(objects))
+	for i, object := range objects {
+		records[i].N = i + 1
+		records[i].User, records[i].Fields, records[i].Err = parseObject(object)
+	}
+	return records, nil
+}
+
+// parseObject returns the user given by the JSON object and the fields it gives.
+func parseObject(object any) (user.User, []string, error) {
+	m, ok := object.(map[string]any)
+	if !ok {
+		return user.User{}, nil, errors.New("not an object")
+	}
+	fields := make(map[string]string, len(m))
+	var books []string
+	for name, v := range m {
+		if !slices.Contains(columns, name) {
+			return user.User{}, nil, fmt.Errorf("unknown field %q", name)
+		}
+		if a, ok := v.([]any); ok && name == "books" {
+			for _, v := range a {
+				book, ok := v.(string)
+				if !ok {
+					return user.User{}, nil, errors.New("the books must be strings")
+				}
+				books = append(books, book)
+			}
+			fields[name] = ""
+			continue
+		}
+		var s string
+		switch v := v.(type) {
+		case nil:
+		case string:
+			s = v
+		case json.Number:
+			s = v.String()
+		case bool:
+			s = "no"
+			if v {
+				s = "yes"
+			}
+		default:
+			return user.User{}, nil, fmt.Errorf("invalid %s", name)
+		}
+		fields[name] = s
+		if name == "books" {
+			books = strings.Split(s, ";")
+		}
+	}
+	u, err := parseUser(fields, books)
+	return u, fieldNames(fields), err
+}
+
+// fieldNames returns the names of the fields of the user, but the ID, in the order of columns.
+func fieldNames(fields map[string]string) []string {
+	var names []string
+	for _, name := range columns[1:] {
+		if _, ok := fields[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// parseUser returns the user given by the fields and the books.
+func parseUser(fields map[string]string, books []string) (u user.User, err error) {
+	if u.Name = strings.TrimSpace(fields["name"]); u.Name == "" {
+		return u, errors.New("no name")
+	}
+	if u.Age, err = user.ParseAge(fields["age"]); err != nil {
+		return u, err
+	}
+	if u.Active, err = user.ParseActive(fields["active"]); err != nil {
+		return u, err
+	}
+	if mass := fields["mass"]; strings.TrimSpace(mass) != "" {
+		if u.Mass, err = user.ParseMass(mass); err != nil {
+			return u, err
+		}
+	}
+	for _, book := range books {
+		if book = strings.TrimSpace(book); book != "" {
+			u.Books = append(u.Books, book)
+		}
+	}
+	return u, nil
+}
+
+// Plan is what the import makes of the records.
+type Plan struct {
+	Added     []user.User // the users without the IDs
+	Updated   []user.User
+	Unchanged []user.User
+	Rejected  []Record
+	swaps     []storage.Swap
+}
+
+// NewPlan compares the records to the users. The record replaces the fields
+// it gives of the user with the same name, or adds a new user. The record
+// repeating the name of a previous one is rejected.
+func NewPlan(users []user.User, records []Record) *Plan {
+	p := &Plan{}
+	byName := make(map[string]int, len(users))
+	for i := len(users) - 1; i >= 0; i-- {
+		byName[users[i].Name] = i
+	}
+	seen := make(map[string]int, len(records))
+	for _, rec := range records {
+		if rec.Err == nil {
+			if n, ok := seen[rec.User.Name]; ok {
+				rec.Err = fmt.Errorf("%q is already imported by record %d", rec.User.Name, n)
+			}
+		}
+		if rec.Err != nil {
+			p.Rejected = append(p.Rejected, rec)
+			continue
+		}
+		seen[rec.User.Name] = rec.N
+
+		i, ok := byName[rec.User.Name]
+		if !ok {
+			u := rec.User
+			p.Added = append(p.Added, u)
+			p.swaps = append(p.swaps, storage.Swap{New: &u})
+			continue
+		}
+		u := merge(users[i], rec)
+		if equalFields(users[i], u) {
+			p.Unchanged = append(p.Unchanged, users[i])
+		} else {
+			p.Updated = append(p.Updated, u)
+			p.swaps = append(p.swaps, storage.Swap{Old: &users[i], New: &u})
+		}
+	}
+	return p
+}
+
+// merge returns the user with the fields given by the record.
+func merge(u user.User, rec Record) user.User {
+	for _, name := range rec.Fields {
+		switch name {
+		case "age":
+			u.Age = rec.User.Age
+		case "active":
+			u.Active = rec.User.Active
+		case "mass":
+			u.Mass = rec.User.Mass
+		case "books":
+			u.Books = rec.User.Books
+		}
+	}
+	return u
+}
+
+// equalFields reports whether the users have the same fields but the ID.
+func equalFields(a, b user.User) bool {
+	return a.Name == b.Name && a.Age == b.Age && a.Active == b.Active &&
+		a.Mass == b.Mass && slices.Equal(a.Books, b.Books)
+}
+
+// Swaps returns the changes of the users the plan makes.
+func (p *Plan) Swaps() []storage.Swap {
+	return p.swaps
+}
+
+// Apply adds and updates the users in one batch. The batch is durable once it
+// is in the log of the storage, so the snapshot isn't saved. If another session
+// has changed the users since the plan is made, nothing is changed and
+// storage.ErrConflict is returned.
+func (p *Plan) Apply(strg *storage.Storage) error {
+	if len(p.swaps) == 0 {
+		return nil
+	}
+	return strg.Apply(p.swaps...)
+}
+
+// maxNames is the number of the names listed in the summary.
+const maxNames = 10
+
+// WriteSummary writes what the import makes: the users to add and to update,
+// the number of the unchanged ones, and the rejected records with the reasons.
+func (p *Plan) WriteSummary(w io.Writer) {
+	fmt.Fprintf(w, "To add: %d%s\n", len(p.Added), names(p.Added))
+	fmt.Fprintf(w, "To update: %d%s\n", len(p.Updated), names(p.Updated))
+	fmt.Fprintf(w, "Unchanged: %d\n", len(p.Unchanged))
+	fmt.Fprintf(w, "Rejected: %d\n", len(p.Rejected))
+	for _, rec := range p.Rejected {
+		fmt.Fprintf(w, "  Record %d: %v\n", rec.N, rec.Err)
+	}
+}
+
+// names returns the list of the names of the users, like " (Ann, Bob)".
+func names(users []user.User) string {
+	if len(users) == 0 {
+		return ""
+	}
+	var list []string
+	for _, u := range users[:min(len(users), maxNames)] {
+		list = append(list, u.Name)
+	}
+	if n := len(users) - maxNames; n > 0 {
+		list = append(list, fmt.Sprintf("and %d more", n))
+	}
+	return " (" + strings.Join(list, ", ") + ")"
+}
+
+// WriteResult writes the numbers of the users the import has changed, and
+// the rejected records with the reasons.
+func (p *Plan) WriteResult(w io.Writer) {
+	fmt.Fprintf(w, "Imported: %d added, %d updated, %d unchanged, %d rejected\n",
+		len(p.Added), len(p.Updated), len(p.Unchanged), len(p.Rejected))
+	for _, rec := range p.Rejected {
+		fmt.Fprintf(w, "  Record %d: %v\n", rec.N, rec.Err)
+	}
+}
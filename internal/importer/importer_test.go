@@ -0,0 +1,186 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"practice/internal/storage"
+	"practice/internal/user"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	jane := user.User{Name: "Jane Doe", Age: 31, Active: true, Mass: 62.5, Books: []string{"Dune", "Emma"}}
+
+	tests := []struct {
+		name    string
+		format  string
+		in      string
+		want    []user.User // the users of the records
+		errs    []string    // the errors of the records; "" if it is valid
+		fields  []string    // the fields of the first record, if not nil
+		wantErr error
+	}{
+		{
+			name:   "csv",
+			format: FormatCSV,
+			in: "\ufeffName,age,active,mass,books\n" +
+				"Jane Doe,31,yes,62.5,Dune;Emma\n" +
+				"\n" +
+				"Bob, , , ,\n" +
+				`"Carl, Jr.",20,no,-5,` + "\n",
+			want: []user.User{jane, {Name: "Bob"}, {Name: "Carl, Jr.", Age: 20}},
+			errs: []string{"", "", ""},
+		},
+		{
+			name:   "csv columns in any order",
+			format: FormatCSV,
+			in:     "books,name,id\n Dune ; Emma ,Ann,7\n",
+			want:   []user.User{{Name: "Ann", Books: []string{"Dune", "Emma"}}},
+			errs:   []string{""},
+			fields: []string{"name", "books"},
+		},
+		{
+			name:   "csv invalid records",
+			format: FormatCSV,
+			in:     "name,age,active,mass\nAnn,old,,\n,1,,\nBob,1,maybe,\nCarl,1,,heavy\nDan,1\n",
+			want:   make([]user.User, 5),
+			errs:   []string{`invalid age "old"`, "no name", `invalid active status "maybe"`, `invalid mass "heavy"`, "2 fields, want 4"},
+		},
+		{
+			name:    "csv unknown column",
+			format:  FormatCSV,
+			in:      "name,height\nAnn,180\n",
+			wantErr: ErrInvalidFile,
+		},
+		{
+			name:    "csv no name",
+			format:  FormatCSV,
+			in:      "age\n30\n",
+			wantErr: ErrInvalidFile,
+		},
+		{
+			name:   "json",
+			format: FormatJSON,
+			in: `[{"id": 5, "name": "Jane Doe", "age": 31, "active": true, "mass": 62.5, "books": ["Dune", "Emma"]},
+				{"name": "Bob", "age": "40", "active": "no", "books": "Dune; Emma", "mass": null}]`,
+			want:   []user.User{jane, {Name: "Bob", Age: 40, Books: []string{"Dune", "Emma"}}},
+			errs:   []string{"", ""},
+			fields: []string{"name", "age", "active", "mass", "books"},
+		},
+		{
+			name:   "json invalid records",
+			format: FormatJSON,
+			in:     `[{"name": "Ann", "age": 300}, "Bob", {"name": "Carl", "height": 180}, {"name": "Dan", "books": [1]}]`,
+			want:   make([]user.User, 4),
+			errs:   []string{`invalid age "300"`, "not an object", `unknown field "height"`, "the books must be strings"},
+		},
+		{
+			name:    "json not an array",
+			format:  FormatJSON,
+			in:      `{"name": "Ann"}`,
+			wantErr: ErrInvalidFile,
+		},
+		{
+			name:    "unknown format",
+			format:  "xml",
+			wantErr: ErrUnknownFormat,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := Read(strings.NewReader(tt.in), tt.format)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Read() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.fields != nil && len(records) > 0 && !reflect.DeepEqual(records[0].Fields, tt.fields) {
+				t.Errorf("Fields = %q, want %q", records[0].Fields, tt.fields)
+			}
+			if len(records) != len(tt.want) {
+				t.Fatalf("Read() = %d records, want %d", len(records), len(tt.want))
+			}
+			for i, rec := range records {
+				if rec.N != i+1 {
+					t.Errorf("record %d: N = %d", i+1, rec.N)
+				}
+				if tt.errs[i] == "" && rec.Err != nil || tt.errs[i] != "" && (rec.Err == nil || !strings.Contains(rec.Err.Error(), tt.errs[i])) {
+					t.Errorf("record %d: error = %v, want %q", i+1, rec.Err, tt.errs[i])
+				}
+				if rec.Err == nil && !reflect.DeepEqual(rec.User, tt.want[i]) {
+					t.Errorf("record %d: user = %+v, want %+v", i+1, rec.User, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	for path, want := range map[string]string{"users.csv": FormatCSV, "dir/Users.JSON": FormatJSON, "users.txt": ""} {
+		got, err := FormatOf(path)
+		if got != want || (err != nil) != (want == "") {
+			t.Errorf("FormatOf(%q) = %q, %v, want %q", path, got, err, want)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	ann := user.User{ID: 1, Name: "Ann", Age: 30, Books: []string{"Dune"}}
+	bob := user.User{ID: 2, Name: "Bob", Age: 40}
+	strg := storage.New(storage.NewMemory(ann, bob))
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+
+	records := []Record{
+		// The books of Ann are kept, as the record doesn't give them.
+		{N: 1, User: user.User{Name: "Ann", Age: 31}, Fields: []string{"name", "age"}},
+		{N: 2, User: user.User{Name: "Bob", Age: 40}, Fields: []string{"name", "age", "books"}},
+		{N: 3, User: user.User{Name: "Carl"}, Fields: []string{"name"}},
+		{N: 4, Err: fmt.Errorf("invalid age %q", "old")},
+		{N: 5, User: user.User{Name: "Carl", Age: 1}},
+	}
+	p := NewPlan(strg.Users(), records)
+
+	var summary strings.Builder
+	p.WriteSummary(&summary)
+	want := "To add: 1 (Carl)\nTo update: 1 (Ann)\nUnchanged: 1\nRejected: 2\n" +
+		"  Record 4: invalid age \"old\"\n  Record 5: \"Carl\" is already imported by record 3\n"
+	if summary.String() != want {
+		t.Errorf("WriteSummary() = %q, want %q", summary.String(), want)
+	}
+
+	if err := p.Apply(strg); err != nil {
+		t.Fatal(err)
+	}
+	wantUsers := []user.User{{ID: 1, Name: "Ann", Age: 31, Books: []string{"Dune"}}, bob, {ID: 3, Name: "Carl"}}
+	if got := strg.Users(); !reflect.DeepEqual(got, wantUsers) {
+		t.Errorf("users = %+v, want %+v", got, wantUsers)
+	}
+	// The plan made before the changes conflicts with them.
+	if err := NewPlan([]user.User{ann, bob}, records[:1]).Apply(strg); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("Apply() of the outdated plan error = %v, want %v", err, storage.ErrConflict)
+	}
+}
+
+// noSnapshotBackend keeps the log, but fails to save the snapshot.
+type noSnapshotBackend struct{ *storage.MemoryBackend }
+
+func (noSnapshotBackend) Snapshot([]user.User, uint64) error { return errors.New("disk is full") }
+
+func TestPlan_ApplyWithoutSnapshot(t *testing.T) {
+	strg := storage.New(noSnapshotBackend{storage.NewMemory()})
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+
+	p := NewPlan(strg.Users(), []Record{{N: 1, User: user.User{Name: "Ann"}}})
+	if err := p.Apply(strg); err != nil {
+		t.Fatalf("Apply() error = %v, want nil: the batch is in the log", err)
+	}
+	if want := []user.User{{ID: 1, Name: "Ann"}}; !reflect.DeepEqual(strg.Users(), want) {
+		t.Errorf("users = %+v, want %+v", strg.Users(), want)
+	}
+}
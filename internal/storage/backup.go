@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"practice/internal/user"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// backupExt is the extension of the backup files of the snapshot.
+	backupExt = ".bak"
+	// backupTimeFormat is the format of the backup timestamps; they are sorted as strings.
+	backupTimeFormat = "20060102-150405.000000"
+	// stdBackups is the number of the backups kept by default.
+	stdBackups = 5
+)
+
+var (
+	ErrNoBackups      = errors.New("storage doesn't keep backups")
+	ErrBackupNotFound = errors.New("backup is not found")
+)
+
+// Backup describes a previous snapshot kept next to the database file.
+type Backup struct {
+	ID   string    `json:"id"` // the timestamp of the backup
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// backupName returns the path of the backup of the snapshot at the path.
+// The backup files are named "<snapshot>.<timestamp>.bak".
+func backupName(path, id string) string {
+	return path + "." + id + backupExt
+}
+
+// backupSnapshot keeps the current snapshot file at the path as a new backup and
+// removes all the backups but the last keep ones. A missing or empty snapshot
+// isn't backed up, as well as the one equal to the newest backup.
+func backupSnapshot(path string, keep int, perms fs.FileMode) error {
+	if keep <= 0 {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && len(data) == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	backups, err := listBackups(path)
+	if err != nil {
+		return err
+	}
+	if len(backups) > 0 && backups[0].Size == int64(len(data)) {
+		last, err := os.ReadFile(backupName(path, backups[0].ID))
+		if err == nil && bytes.Equal(last, data) {
+			return nil
+		}
+	}
+
+	// If the snapshots are made faster than the clock ticks, the last one is kept.
+	bak := backupName(path, time.Now().UTC().Format(backupTimeFormat))
+	if err = os.WriteFile(bak, data, perms); err != nil {
+		return err
+	}
+
+	if backups, err = listBackups(path); err != nil {
+		return err
+	}
+	for _, b := range backups[min(keep, len(backups)):] {
+		if err = os.Remove(backupName(path, b.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listBackups returns the backups of the snapshot at the path, the newest first.
+func listBackups(path string) ([]Backup, error) {
+	// The path may contain the glob metacharacters, so only its directory is listed.
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var backups []Backup
+	for _, entry := range entries {
+		id, ok := strings.CutPrefix(entry.Name(), name+".")
+		if !ok {
+			continue
+		}
+		if id, ok = strings.CutSuffix(id, backupExt); !ok {
+			continue
+		}
+		t, err := time.Parse(backupTimeFormat, id)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed meanwhile
+		}
+		backups = append(backups, Backup{ID: id, Time: t, Size: info.Size()})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].ID > backups[j].ID })
+	return backups, nil
+}
+
+// readBackup decodes the users of the backup of the snapshot at the path.
+// The damaged backups are rejected.
+func readBackup(path, id string) ([]user.User, error) {
+	if _, err := time.Parse(backupTimeFormat, id); err != nil {
+		return nil, ErrBackupNotFound
+	}
+	data, err := os.ReadFile(backupName(path, id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrBackupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user.Decode(bytes.NewReader(data))
+}
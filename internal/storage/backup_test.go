@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"practice/internal/user"
+	"reflect"
+	"testing"
+)
+
+func TestStorage_Backups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.database")
+	backend, err := OpenFile(path, WithBackups(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	strg := load(t, backend)
+	defer strg.Close()
+
+	// states[i] is the contents of the i-th snapshot.
+	var states [][]user.User
+	for _, name := range []string{"John Doe", "Jake Doe", "Jane Doe"} {
+		if _, err = strg.Add(user.User{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+		if err = strg.SaveSnapshot(); err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, strg.Users())
+	}
+	// The same snapshot isn't backed up twice.
+	if err = strg.SaveSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := strg.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("Backups() returned %d backups, want 2", len(backups))
+	}
+	for i, b := range backups {
+		got, err := backend.ReadBackup(b.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := states[len(states)-1-i]; !reflect.DeepEqual(got, want) {
+			t.Errorf("backup %d = %+v, want %+v", i, got, want)
+		}
+	}
+
+	// Restore the oldest backup after some changes.
+	if err = strg.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+	changed := strg.Users()
+	if err = strg.Restore(backups[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := strg.Users(); !reflect.DeepEqual(got, states[1]) {
+		t.Errorf("Users() = %+v, want %+v", got, states[1])
+	}
+	// The restored state survives reopening.
+	strg.Close()
+	backend, err = OpenFile(path, WithBackups(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	strg = load(t, backend)
+	if got := strg.Users(); !reflect.DeepEqual(got, states[1]) {
+		t.Errorf("Users() after reopening = %+v, want %+v", got, states[1])
+	}
+	// The state before the restoring is the newest backup.
+	backups, _ = strg.Backups()
+	if got, _ := backend.ReadBackup(backups[0].ID); !reflect.DeepEqual(got, changed) {
+		t.Errorf("newest backup = %+v, want %+v", got, changed)
+	}
+
+	if err = strg.Restore("20000101-000000.000000"); !errors.Is(err, ErrBackupNotFound) {
+		t.Errorf("Restore() of missing backup error = %v, want %v", err, ErrBackupNotFound)
+	}
+	if err = strg.Restore("../test.database"); !errors.Is(err, ErrBackupNotFound) {
+		t.Errorf("Restore() of invalid ID error = %v, want %v", err, ErrBackupNotFound)
+	}
+}
+
+func TestStorage_NoBackups(t *testing.T) {
+	strg := load(t, NewMemory())
+	defer strg.Close()
+	if _, err := strg.Backups(); !errors.Is(err, ErrNoBackups) {
+		t.Errorf("Backups() error = %v, want %v", err, ErrNoBackups)
+	}
+	if err := strg.Restore("20000101-000000.000000"); !errors.Is(err, ErrNoBackups) {
+		t.Errorf("Restore() error = %v, want %v", err, ErrNoBackups)
+	}
+}
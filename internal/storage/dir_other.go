@@ -0,0 +1,8 @@
+//go:build !unix
+
+package storage
+
+// syncDir does nothing: the directories can't be synced on this platform.
+func syncDir(dir string) error {
+	return nil
+}
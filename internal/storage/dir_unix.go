@@ -0,0 +1,18 @@
+//go:build unix
+
+package storage
+
+import "os"
+
+// syncDir commits the directory entries (e.g. a renamed file) to the disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err = d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
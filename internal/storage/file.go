@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"practice/internal/user"
+)
+
+const (
+	// quarantineExt is the extension of the file with the damaged data found by Load.
+	quarantineExt = ".quarantine"
+	// logExt is the extension of the write-ahead log file.
+	logExt = ".log"
+)
+
+// FileBackend keeps the snapshot in a file and the log in the file next to it
+// with the ".log" extension. The backend holds the exclusive lock of the ".lock" file,
+// so only one process can open the database for writing.
+type FileBackend struct {
+	path string
+	log  *os.File
+	lock *os.File // nil if read-only
+
+	cfg    config
+	syncer *syncer
+}
+
+// OpenFile opens the backend with the snapshot file at the path. If the database
+// is in use by another process, the *LockedError is returned. The WithPath option
+// is ignored.
+func OpenFile(path string, opts ...Option) (*FileBackend, error) {
+	cfg := newConfig(opts)
+	cfg.path = path
+	return openFile(cfg)
+}
+
+func openFile(cfg config) (*FileBackend, error) {
+	if cfg.readOnly {
+		return openFileReadOnly(cfg)
+	}
+	lock, err := acquireLock(cfg.path+lockExt, cfg.filePerms)
+	if err != nil {
+		return nil, err
+	}
+
+	// The snapshot is replaced by rename, so it isn't kept open.
+	flags := os.O_RDONLY
+	if cfg.create {
+		flags |= os.O_CREATE
+	}
+	file, err := os.OpenFile(cfg.path, flags, cfg.filePerms)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	file.Close()
+
+	logFile, err := openLog(cfg.path+logExt, cfg.filePerms)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	if cfg.sync != SyncNever {
+		// Commit the entries of the files if they are new.
+		if err = syncDir(filepath.Dir(cfg.path)); err != nil {
+			logFile.Close()
+			lock.Close()
+			return nil, err
+		}
+	}
+	return &FileBackend{
+		path:   cfg.path,
+		log:    logFile,
+		lock:   lock,
+		cfg:    cfg,
+		syncer: newSyncer(cfg.sync),
+	}, nil
+}
+
+// openFileReadOnly opens the existing database for reading. It doesn't take
+// the lock, so the database may be in use by another process at the same time.
+func openFileReadOnly(cfg config) (*FileBackend, error) {
+	if _, err := os.Stat(cfg.path); err != nil {
+		return nil, err
+	}
+	logFile, err := os.Open(cfg.path + logExt)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return &FileBackend{path: cfg.path, log: logFile, cfg: cfg, syncer: newSyncer(SyncNever)}, nil
+}
+
+// Load decodes the snapshot file and reads the log. The damaged records
+// of the snapshot are copied to the quarantine file next to it.
+func (b *FileBackend) Load() (users []user.User, nextID uint64, ops []Op, err error) {
+	users, nextID, err = loadSnapshot(b.path, b.cfg)
+	var corruption *user.CorruptionError
+	if err != nil && !errors.As(err, &corruption) {
+		return nil, 0, nil, err
+	}
+	if b.log == nil {
+		return users, nextID, nil, err
+	}
+	ops, logErr := readLog(b.log, !b.cfg.readOnly)
+	if logErr != nil {
+		return nil, 0, nil, logErr
+	}
+	return users, nextID, ops, err
+}
+
+func (b *FileBackend) Append(ops []Op) error {
+	if b.cfg.readOnly {
+		return ErrReadOnly
+	}
+	if err := appendLog(b.log, ops); err != nil {
+		return err
+	}
+	return b.syncer.written(b.log)
+}
+
+// Snapshot replaces the snapshot file and empties the log.
+func (b *FileBackend) Snapshot(users []user.User, nextID uint64) error {
+	if b.cfg.readOnly {
+		return ErrReadOnly
+	}
+	if err := writeSnapshot(b.path, users, nextID, b.cfg); err != nil {
+		return err
+	}
+	// The snapshot contains all the changes of the log. If the program stops
+	// before the log is emptied, replaying the log over the snapshot is harmless.
+	if err := resetLog(b.log); err != nil {
+		return err
+	}
+	return b.syncer.written(b.log)
+}
+
+// Close commits and closes the log, and releases the lock.
+func (b *FileBackend) Close() (err error) {
+	err = b.syncer.close()
+	if b.log != nil {
+		if closeErr := b.log.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if b.lock != nil {
+		if lockErr := b.lock.Close(); err == nil {
+			err = lockErr
+		}
+	}
+	return err
+}
+
+// Backups returns the backups of the snapshot, the newest first.
+func (b *FileBackend) Backups() ([]Backup, error) {
+	return listBackups(b.path)
+}
+
+// ReadBackup decodes the users of the backup with the ID.
+func (b *FileBackend) ReadBackup(id string) ([]user.User, error) {
+	return readBackup(b.path, id)
+}
+
+// ReadOnly reports whether the backend rejects the changes.
+func (b *FileBackend) ReadOnly() bool {
+	return b.cfg.readOnly
+}
+
+// Name returns the name of the snapshot file.
+func (b *FileBackend) Name() string {
+	_, name := filepath.Split(b.path)
+	return name
+}
+
+// loadSnapshot decodes the users and the next ID of the snapshot file at the path. Unless the
+// database is read-only, the damaged records are moved to the quarantine file:
+// they are copied to it, and the snapshot is rewritten without them, so the next
+// load doesn't quarantine them again. The *user.CorruptionError is returned.
+func loadSnapshot(path string, cfg config) ([]user.User, uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	users, nextID, err := user.DecodeNextID(bytes.NewReader(data))
+	var corruption *user.CorruptionError
+	if errors.As(err, &corruption) && !cfg.readOnly {
+		if qErr := quarantine(path+quarantineExt, data, corruption.Damaged, cfg.filePerms); qErr != nil {
+			return nil, 0, qErr
+		}
+		if wErr := writeSnapshot(path, users, nextID, cfg); wErr != nil {
+			return nil, 0, wErr
+		}
+	}
+	return users, nextID, err
+}
+
+// writeSnapshot atomically replaces the snapshot file at the path.
+// The previous snapshot is kept as a backup (see WithBackups).
+// Unless the sync policy is SyncNever, the new file and its directory entry
+// are committed to the disk.
+func writeSnapshot(path string, users []user.User, nextID uint64, cfg config) (err error) {
+	// Create a temporary storage file in the same directory, so it can be renamed.
+	// The directory of a bare file name is ".", not "".
+	dir := filepath.Dir(path)
+	tmpFile, err := os.CreateTemp(dir, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	tmpFilePath := tmpFile.Name()
+	defer func() {
+		if err != nil {
+			tmpFile.Close()
+			os.Remove(tmpFilePath)
+		}
+	}()
+
+	// Encode data and save it to the temp file.
+	if err = user.EncodeNextID(tmpFile, users, nextID); err != nil {
+		return err
+	}
+	if cfg.sync != SyncNever {
+		if err = tmpFile.Sync(); err != nil {
+			return err
+		}
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+
+	// Rename (move) temporary into the main storage file.
+	if err = os.Chmod(tmpFilePath, cfg.filePerms); err != nil {
+		return err
+	}
+	if err = backupSnapshot(path, cfg.backups, cfg.filePerms); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpFilePath, path); err != nil {
+		return err
+	}
+	if cfg.sync == SyncNever {
+		return nil
+	}
+	return syncDir(dir)
+}
+
+// quarantine appends the damaged regions of data to the quarantine file.
+func quarantine(path string, data []byte, damaged []user.Damage, perms fs.FileMode) error {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	file, err := os.OpenFile(path, flags, perms)
+	if err != nil {
+		return err
+	}
+	for _, d := range damaged {
+		if _, err = file.Write(data[d.Offset : d.Offset+d.Length]); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"practice/internal/user"
+	"reflect"
+	"testing"
+)
+
+func TestFileBackend_TornLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.database")
+	open := func() Backend {
+		b, err := OpenFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	strg := load(t, open())
+	john, _ := strg.Add(user.User{Name: "John Doe"})
+	if _, err := strg.Add(user.User{Name: "Jake Doe"}); err != nil {
+		t.Fatal(err)
+	}
+	strg.Close()
+
+	// Cut the last batch in the middle.
+	info, err := os.Stat(path + logExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Truncate(path+logExt, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	strg = load(t, open())
+	want := []user.User{john}
+	if got := strg.Users(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Users() = %+v, want %+v", got, want)
+	}
+	// New batches go after the valid part of the log.
+	jane, _ := strg.Add(user.User{Name: "Jane Doe"})
+	strg.Close()
+
+	strg = load(t, open())
+	defer strg.Close()
+	want = append(want, jane)
+	if got := strg.Users(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Users() = %+v, want %+v", got, want)
+	}
+}
+
+func TestFileBackend_Quarantine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.database")
+	users := []user.User{{ID: 1, Name: "John Doe"}, {ID: 2, Name: "Jake Doe"}}
+	if err := writeSnapshot(path, users, 3, newConfig(nil)); err != nil {
+		t.Fatal(err)
+	}
+	// Damage the last byte of the checksum of the last record.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err = os.WriteFile(path, data, filePerms); err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strg := New(backend)
+	defer strg.Close()
+	if _, ok := strg.Load().(*user.CorruptionError); !ok {
+		t.Fatalf("Load() error isn't *user.CorruptionError")
+	}
+	if got := strg.Users(); !reflect.DeepEqual(got, users[:1]) {
+		t.Errorf("Users() = %+v, want %+v", got, users[:1])
+	}
+	info, err := os.Stat(path + quarantineExt)
+	if err != nil || info.Size() == 0 {
+		t.Fatalf("quarantine file: %v, %v", info, err)
+	}
+	strg.Close()
+
+	// The snapshot is rewritten without the damaged record, so it isn't quarantined again.
+	if backend, err = OpenFile(path); err != nil {
+		t.Fatal(err)
+	}
+	strg = New(backend)
+	defer strg.Close()
+	if err = strg.Load(); err != nil {
+		t.Errorf("second Load() error = %v", err)
+	}
+	if got := strg.Users(); !reflect.DeepEqual(got, users[:1]) {
+		t.Errorf("Users() after reload = %+v, want %+v", got, users[:1])
+	}
+	again, err := os.Stat(path + quarantineExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Size() != info.Size() {
+		t.Errorf("quarantine file size after reload = %d, want %d", again.Size(), info.Size())
+	}
+}
+
+func TestFileBackend_BareFileName(t *testing.T) {
+	// The snapshot is written next to the file in the working directory.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	backend, err := OpenFile("test.database")
+	if err != nil {
+		t.Fatal(err)
+	}
+	strg := load(t, backend)
+	defer strg.Close()
+	if _, err = strg.Add(user.User{Name: "John Doe"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = strg.SaveSnapshot(); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+	if users, _, err := loadSnapshot("test.database", newConfig(nil)); err != nil || len(users) != 1 {
+		t.Errorf("snapshot = %+v, %v, want John Doe", users, err)
+	}
+}
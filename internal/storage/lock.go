@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// lockExt is the extension of the lock file of the database.
+const lockExt = ".lock"
+
+var (
+	ErrLocked   = errors.New("database is locked")
+	ErrReadOnly = errors.New("database is opened read-only")
+)
+
+// LockedError is returned when the database is in use by another process.
+type LockedError struct {
+	Path string
+	PID  int // 0 if unknown
+}
+
+func (e *LockedError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("database %s is in use by another process", e.Path)
+	}
+	return fmt.Sprintf("database %s is in use by the process %d", e.Path, e.PID)
+}
+
+func (e *LockedError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// acquireLock takes the exclusive lock of the lock file at the path
+// and writes the PID of the current process into it. The lock is held
+// until the returned file is closed.
+func acquireLock(path string, perms fs.FileMode) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, perms)
+	if err != nil {
+		return nil, err
+	}
+	if err = lockFile(file); err != nil {
+		file.Close()
+		if errors.Is(err, errWouldBlock) {
+			return nil, &LockedError{Path: path, PID: readPID(path)}
+		}
+		return nil, fmt.Errorf("couldn't lock %s: %w", path, err)
+	}
+
+	if err = file.Truncate(0); err == nil {
+		_, err = file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// readPID returns the PID written into the lock file, or 0 if there is none.
+func readPID(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
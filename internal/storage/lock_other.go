@@ -0,0 +1,16 @@
+//go:build !unix
+
+package storage
+
+import (
+	"errors"
+	"os"
+)
+
+var errWouldBlock = errors.New("lock is held")
+
+// lockFile does nothing: the advisory locks are supported on unix only,
+// so the lock file just records the PID of the process.
+func lockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"practice/internal/user"
+	"reflect"
+	"testing"
+)
+
+func TestOpenFile_Locked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.database")
+	first, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strg := load(t, first)
+	john, err := strg.Add(user.User{Name: "John Doe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenFile(path)
+	var locked *LockedError
+	if !errors.As(err, &locked) || !errors.Is(err, ErrLocked) {
+		t.Fatalf("OpenFile() error = %v, want *LockedError", err)
+	}
+	if locked.PID != os.Getpid() {
+		t.Errorf("OpenFile() holder PID = %d, want %d", locked.PID, os.Getpid())
+	}
+
+	// The read-only mode doesn't need the lock.
+	ro, err := OpenFile(path, WithReadOnly(true))
+	if err != nil {
+		t.Fatalf("OpenFile() read-only error = %v", err)
+	}
+	roStrg := load(t, ro)
+	if got := roStrg.Users(); !reflect.DeepEqual(got, []user.User{john}) {
+		t.Errorf("Users() = %+v, want %+v", got, []user.User{john})
+	}
+	if _, err = roStrg.Add(user.User{Name: "Jake Doe"}); err != ErrReadOnly {
+		t.Errorf("Add() error = %v, want %v", err, ErrReadOnly)
+	}
+	roStrg.Close()
+
+	// The lock is released on close.
+	if err = strg.Close(); err != nil {
+		t.Fatal(err)
+	}
+	second, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	second.Close()
+}
+
+func TestOpenSegments_Locked(t *testing.T) {
+	dir := t.TempDir()
+	first, err := OpenSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if _, err = OpenSegments(dir); !errors.Is(err, ErrLocked) {
+		t.Errorf("OpenSegments() error = %v, want %v", err, ErrLocked)
+	}
+}
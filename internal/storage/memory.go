@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"practice/internal/user"
+	"sync"
+)
+
+// MemoryBackend keeps the snapshot and the log in memory. It is meant for tests.
+type MemoryBackend struct {
+	mu       sync.Mutex
+	snapshot []user.User
+	nextID   uint64
+	log      []Op
+}
+
+// NewMemory returns a new MemoryBackend with the users in its snapshot.
+func NewMemory(users ...user.User) *MemoryBackend {
+	return &MemoryBackend{snapshot: cloneUsers(users), nextID: user.Slice(users).NextID()}
+}
+
+func (b *MemoryBackend) Load() (users []user.User, nextID uint64, ops []Op, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ops = make([]Op, len(b.log))
+	copy(ops, b.log)
+	return cloneUsers(b.snapshot), b.nextID, ops, nil
+}
+
+func (b *MemoryBackend) Append(ops []Op) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.log = append(b.log, ops...)
+	return nil
+}
+
+func (b *MemoryBackend) Snapshot(users []user.User, nextID uint64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.snapshot, b.nextID = cloneUsers(users), nextID
+	b.log = nil
+	return nil
+}
+
+func (b *MemoryBackend) Close() error {
+	return nil
+}
+
+// cloneUsers returns a copy of the users that doesn't share the books with them.
+func cloneUsers(users []user.User) []user.User {
+	if users == nil {
+		return nil
+	}
+	out := make([]user.User, len(users))
+	for i, u := range users {
+		u.Books = append([]string(nil), u.Books...)
+		out[i] = u
+	}
+	return out
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// SyncPolicy defines when the written data is committed to the disk (fsync).
+//
+// With SyncAlways, every change is committed before it is acknowledged, so no
+// acknowledged change is lost on a crash of the system. With SyncEvery, the log
+// is committed in the background once per interval, and the changes of the last
+// interval may be lost. With SyncNever, committing the data is left to the operating
+// system. In any case, a crash never leaves the database unreadable: the torn tail
+// of the log is dropped on Load, and the snapshot is replaced atomically.
+//
+// The snapshots are committed, including the directory entry of the renamed file,
+// with every policy but SyncNever.
+type SyncPolicy struct {
+	interval time.Duration // 0 commits every change, -1 never commits
+}
+
+var (
+	SyncAlways = SyncPolicy{}
+	SyncNever  = SyncPolicy{interval: -1}
+)
+
+// SyncEvery returns the policy that commits the log once per interval.
+// The non-positive interval means SyncAlways.
+func SyncEvery(interval time.Duration) SyncPolicy {
+	if interval <= 0 {
+		return SyncAlways
+	}
+	return SyncPolicy{interval: interval}
+}
+
+// ParseSyncPolicy parses the policy written as "always", "never" or the interval
+// of SyncEvery, such as "100ms".
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "always":
+		return SyncAlways, nil
+	case "never":
+		return SyncNever, nil
+	}
+	interval, err := time.ParseDuration(s)
+	if err != nil || interval <= 0 {
+		return SyncPolicy{}, fmt.Errorf("invalid sync policy %q", s)
+	}
+	return SyncEvery(interval), nil
+}
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncAlways:
+		return "always"
+	case SyncNever:
+		return "never"
+	}
+	return "every " + p.interval.String()
+}
+
+// config holds the settings of the file backends.
+type config struct {
+	path      string
+	create    bool
+	readOnly  bool
+	dirPerms  fs.FileMode
+	filePerms fs.FileMode
+	sync      SyncPolicy
+	backups   int
+}
+
+// Option configures the storage opened by NewStorage, OpenFile or OpenSegments.
+type Option func(*config)
+
+func newConfig(opts []Option) config {
+	cfg := config{
+		path:      filepath.Join(stdFileDir, stdFileName),
+		create:    true,
+		dirPerms:  dirPerms,
+		filePerms: filePerms,
+		sync:      SyncAlways,
+		backups:   stdBackups,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithPath sets the path of the database file opened by NewStorage.
+// By default, it is "datafiles/test.database".
+func WithPath(path string) Option {
+	return func(c *config) {
+		c.path = path
+	}
+}
+
+// WithCreate sets whether a missing database is created (the default)
+// or an error is returned.
+func WithCreate(create bool) Option {
+	return func(c *config) {
+		c.create = create
+	}
+}
+
+// WithReadOnly opens the database for reading only. A read-only database
+// isn't locked, and all the changes are rejected with ErrReadOnly.
+func WithReadOnly(readOnly bool) Option {
+	return func(c *config) {
+		c.readOnly = readOnly
+	}
+}
+
+// WithPerms sets the permissions of the created directories and files.
+func WithPerms(dir, file fs.FileMode) Option {
+	return func(c *config) {
+		c.dirPerms, c.filePerms = dir, file
+	}
+}
+
+// WithBackups sets the number of the previous snapshots kept as the backup files
+// next to the snapshot. By default, 5 backups are kept; 0 disables the backups.
+func WithBackups(n int) Option {
+	return func(c *config) {
+		c.backups = n
+	}
+}
+
+// WithSync sets the policy of committing the changes to the disk.
+func WithSync(policy SyncPolicy) Option {
+	return func(c *config) {
+		c.sync = policy
+	}
+}
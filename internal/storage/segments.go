@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"practice/internal/user"
+	"sort"
+)
+
+const (
+	// snapshotName is the name of the snapshot file in the segments directory.
+	snapshotName = "snapshot.database"
+	// segmentPattern is the pattern of the names of the log segments.
+	segmentPattern = "%08d" + logExt
+	// segmentsLockName is the name of the lock file in the segments directory.
+	segmentsLockName = "segments" + lockExt
+	// stdSegmentSize is the size of the log segment that makes a new segment to be started.
+	stdSegmentSize = 16 << 10 // 16 KiB
+)
+
+// SegmentsBackend keeps the snapshot and the log in a directory. The log is split
+// into the numbered segment files; only the last one of them is appended to.
+type SegmentsBackend struct {
+	dir     string
+	lock    *os.File
+	segment *os.File // the last segment
+	seq     int      // the number of the last segment
+	size    int64    // the size of the last segment
+
+	segmentSize int64
+	cfg         config
+	syncer      *syncer
+}
+
+// OpenSegments opens the backend in the directory, creating it if needed.
+// If the directory is in use by another process, the *LockedError is returned.
+// The WithPath and WithReadOnly options are ignored.
+func OpenSegments(dir string, opts ...Option) (*SegmentsBackend, error) {
+	cfg := newConfig(opts)
+	if cfg.create {
+		if err := os.MkdirAll(dir, cfg.dirPerms); err != nil {
+			return nil, err
+		}
+	}
+	lock, err := acquireLock(filepath.Join(dir, segmentsLockName), cfg.filePerms)
+	if err != nil {
+		return nil, err
+	}
+	b := &SegmentsBackend{
+		dir:         dir,
+		lock:        lock,
+		segmentSize: stdSegmentSize,
+		cfg:         cfg,
+		syncer:      newSyncer(cfg.sync),
+	}
+
+	seqs, err := b.segments()
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	seq := 1
+	if len(seqs) > 0 {
+		seq = seqs[len(seqs)-1]
+	}
+	if err = b.openSegment(seq); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// segments returns the sorted numbers of the segments in the directory.
+func (b *SegmentsBackend) segments() (seqs []int, err error) {
+	matches, err := filepath.Glob(filepath.Join(b.dir, "*"+logExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, match := range matches {
+		var seq int
+		if _, err := fmt.Sscanf(filepath.Base(match), segmentPattern, &seq); err == nil {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Ints(seqs)
+	return seqs, nil
+}
+
+func (b *SegmentsBackend) segmentPath(seq int) string {
+	return filepath.Join(b.dir, fmt.Sprintf(segmentPattern, seq))
+}
+
+// openSegment makes the segment with the number seq the last one.
+func (b *SegmentsBackend) openSegment(seq int) error {
+	file, err := openLog(b.segmentPath(seq), b.cfg.filePerms)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err == nil && b.cfg.sync != SyncNever {
+		// Commit the entry of a new segment, so its changes aren't lost with it.
+		err = syncDir(b.dir)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if b.segment != nil {
+		if err = b.syncer.flush(); err != nil {
+			file.Close()
+			return err
+		}
+		b.segment.Close()
+	}
+	b.segment, b.seq, b.size = file, seq, info.Size()
+	return nil
+}
+
+// Load decodes the snapshot and reads the segments in order.
+func (b *SegmentsBackend) Load() (users []user.User, nextID uint64, ops []Op, err error) {
+	users, nextID, err = loadSnapshot(filepath.Join(b.dir, snapshotName), b.cfg)
+	var corruption *user.CorruptionError
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		users, err = nil, nil
+	case err != nil && !errors.As(err, &corruption):
+		return nil, 0, nil, err
+	}
+
+	seqs, segErr := b.segments()
+	if segErr != nil {
+		return nil, 0, nil, segErr
+	}
+	for _, seq := range seqs {
+		var segOps []Op
+		var segErr error
+		if seq == b.seq {
+			segOps, segErr = readLog(b.segment, true)
+		} else {
+			segOps, segErr = readSegment(b.segmentPath(seq))
+		}
+		if segErr != nil {
+			return nil, 0, nil, segErr
+		}
+		ops = append(ops, segOps...)
+	}
+	return users, nextID, ops, err
+}
+
+// readSegment reads the operations of a closed segment.
+func readSegment(path string) ([]Op, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	ops, _, err := decodeLog(file)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read log %s: %w", path, err)
+	}
+	return ops, nil
+}
+
+// Append appends the batch to the last segment. A new segment is started
+// when the last one outgrows the segment size.
+func (b *SegmentsBackend) Append(ops []Op) error {
+	if b.size > b.segmentSize {
+		if err := b.openSegment(b.seq + 1); err != nil {
+			return err
+		}
+	}
+	if err := appendLog(b.segment, ops); err != nil {
+		return err
+	}
+	if err := b.syncer.written(b.segment); err != nil {
+		return err
+	}
+	info, err := b.segment.Stat()
+	if err != nil {
+		return err
+	}
+	b.size = info.Size()
+	return nil
+}
+
+// Snapshot replaces the snapshot and removes all the segments but a new empty one.
+func (b *SegmentsBackend) Snapshot(users []user.User, nextID uint64) error {
+	if err := writeSnapshot(filepath.Join(b.dir, snapshotName), users, nextID, b.cfg); err != nil {
+		return err
+	}
+	seqs, err := b.segments()
+	if err != nil {
+		return err
+	}
+	if err = b.openSegment(b.seq + 1); err != nil {
+		return err
+	}
+	// The snapshot contains all the changes of the segments. If the program stops
+	// before they are removed, replaying them over the snapshot is harmless.
+	for _, seq := range seqs {
+		if err = os.Remove(b.segmentPath(seq)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Backups returns the backups of the snapshot, the newest first.
+func (b *SegmentsBackend) Backups() ([]Backup, error) {
+	return listBackups(filepath.Join(b.dir, snapshotName))
+}
+
+// ReadBackup decodes the users of the backup with the ID.
+func (b *SegmentsBackend) ReadBackup(id string) ([]user.User, error) {
+	return readBackup(filepath.Join(b.dir, snapshotName), id)
+}
+
+// Close commits and closes the last segment, and releases the lock.
+func (b *SegmentsBackend) Close() error {
+	err := b.syncer.close()
+	if closeErr := b.segment.Close(); err == nil {
+		err = closeErr
+	}
+	if lockErr := b.lock.Close(); err == nil {
+		err = lockErr
+	}
+	return err
+}
+
+// Name returns the name of the directory.
+func (b *SegmentsBackend) Name() string {
+	return filepath.Base(b.dir)
+}
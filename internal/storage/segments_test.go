@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"fmt"
+	"practice/internal/user"
+	"reflect"
+	"testing"
+)
+
+func TestSegmentsBackend_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	backend, err := OpenSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.segmentSize = 128
+	strg := load(t, backend)
+	strg.compactThreshold = 1 << 20
+
+	for i := 0; i < 20; i++ {
+		if _, err = strg.Add(user.User{Name: fmt.Sprintf("User %d", i), Age: uint8(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := strg.Users()
+	if seqs, _ := backend.segments(); len(seqs) < 2 {
+		t.Errorf("segments() = %v, want several segments", seqs)
+	}
+	strg.Close()
+
+	reopen := func() *SegmentsBackend {
+		b, err := OpenSegments(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	strg = load(t, reopen())
+	if got := strg.Users(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Users() returned %d users, want %d", len(got), len(want))
+	}
+
+	// The snapshot removes the old segments.
+	if err = strg.SaveSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	strg.Close()
+	backend = reopen()
+	if seqs, _ := backend.segments(); len(seqs) != 1 {
+		t.Errorf("segments() = %v, want one segment", seqs)
+	}
+	strg = load(t, backend)
+	defer strg.Close()
+	if got := strg.Users(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Users() returned %d users, want %d", len(got), len(want))
+	}
+}
@@ -1,13 +1,15 @@
 package storage
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
-	"io"
-	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
 	"practice/internal/user"
+	"sync"
+
+	"golang.org/x/exp/slices"
 )
 
 const (
@@ -15,115 +17,351 @@ const (
 	stdFileName = "test.database"
 	dirPerms    = 0775 // rwxrwxr-x
 	filePerms   = 0664 // rw-rw-r--
+
+	// compactThreshold is the size of the log that triggers the compaction.
+	compactThreshold = 64 << 10 // 64 KiB
 )
 
+var (
+	ErrNotFound  = errors.New("user is not found")
+	ErrConflict  = errors.New("user has been changed")
+	ErrNameTaken = errors.New("user already exists")
+)
+
+// Backend persists the users of the Storage as a snapshot and a log
+// of the operations made after the snapshot.
+type Backend interface {
+	// Load returns the users of the snapshot, the ID for the next new user kept
+	// with the snapshot, and the logged operations.
+	// If some of the snapshot records are damaged, Load returns the rest of the data
+	// along with the *user.CorruptionError.
+	Load() (users []user.User, nextID uint64, ops []Op, err error)
+	// Append appends the batch of operations to the log.
+	Append(ops []Op) error
+	// Snapshot replaces the snapshot with the users and the next ID, and empties the log.
+	Snapshot(users []user.User, nextID uint64) error
+	// Close releases the resources of the backend.
+	Close() error
+}
+
+// Storage keeps the users in memory. Every change of the users is appended
+// to the log of the backend, which is replayed over the snapshot on Load.
+// When the log grows over the threshold, it is compacted into a new snapshot
+// in the background. Storage is safe for concurrent use.
 type Storage struct {
-	file *os.File
-	path string
+	mu sync.Mutex
+	// compaction waits for the background compaction to finish.
+	compaction sync.WaitGroup
+
+	backend Backend
+	users   []user.User
+	// nextID is the ID for the next added user. It never decreases,
+	// so the IDs of the deleted users aren't given again.
+	nextID uint64
+
+	logSize          int64
+	compactThreshold int64
+	compacting       bool
 }
 
-// NewStorage returns a new Storage...
-func NewStorage() (strg *Storage, err error) {
-	var fileDir, fileName string
-	if len(os.Args) < 2 {
-		fmt.Println("No name for the database file. 'test.database' file is used.")
-		fileDir, fileName = stdFileDir, stdFileName
-	} else {
-		fileDir, fileName = filepath.Split(os.Args[1])
-	}
+// NewStorage returns a new Storage that keeps the users in the database file
+// (see FileBackend). The file is configured by the options.
+func NewStorage(opts ...Option) (*Storage, error) {
+	cfg := newConfig(opts)
 
-	if len(fileDir) > 0 {
-		err = os.MkdirAll(fileDir, dirPerms)
-		if err != nil {
+	if dir := filepath.Dir(cfg.path); cfg.create && !cfg.readOnly {
+		if err := os.MkdirAll(dir, cfg.dirPerms); err != nil {
 			return nil, err
 		}
 	}
-	filePath := filepath.Join(fileDir, fileName)
-
-	strg = new(Storage)
-	strg.path = filePath
-	if err = strg.Open(); err != nil {
+	backend, err := openFile(cfg)
+	if err != nil {
 		return nil, err
 	}
+	return New(backend), nil
+}
 
-	return strg, nil
+// New returns a new Storage that keeps the users in the backend.
+func New(backend Backend) *Storage {
+	return &Storage{
+		backend:          backend,
+		nextID:           1,
+		compactThreshold: compactThreshold,
+	}
 }
 
-// Open opens the storage file.
-func (s *Storage) Open() (err error) {
-	flags := os.O_CREATE | os.O_RDWR | os.O_APPEND
-	s.file, err = os.OpenFile(s.path, flags, fs.FileMode(filePerms))
+// Load loads the snapshot of the backend and replays the log over it.
+// If some of the snapshot records are damaged, the rest of the users are loaded
+// and the *user.CorruptionError is returned.
+func (s *Storage) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users, nextID, ops, err := s.backend.Load()
+	var corruption *user.CorruptionError
+	if err != nil && !errors.As(err, &corruption) {
+		return err
+	}
+	s.nextID = nextIDAfter(max(nextID, user.Slice(users).NextID()), ops)
+	s.users = replay(users, ops)
+	s.logSize = batchSize(ops)
 	return err
 }
 
-func (s *Storage) Close() error {
-	return s.file.Close()
+// Users returns a copy of the users.
+func (s *Storage) Users() []user.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := make([]user.User, len(s.users))
+	copy(users, s.users)
+	return users
 }
 
-func (s *Storage) Sync() error {
-	return s.file.Sync()
+// NextID returns the ID the next added user gets, unless others are added before.
+func (s *Storage) NextID() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nextID
 }
 
-func (s *Storage) SaveSnapshot(users *[]user.User) (err error) {
-	// Create a temporary storage file.
-	tmpDir, tmpFileName := filepath.Split(s.path)
-	tmpFile, err := os.CreateTemp(tmpDir, tmpFileName)
-	if err != nil {
-		return err
+// Add adds the user with a new ID and returns them.
+// If another user has the name, ErrNameTaken is returned.
+func (s *Storage) Add(u user.User) (user.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u.ID = s.nextID
+	if err := CheckName(s.users, u); err != nil {
+		return u, err
 	}
-	tmpFilePath := tmpFile.Name()
+	return u, s.apply(Op{Kind: OpAdd, User: u})
+}
 
-	// Encode data and save it to the temp file.
-	if err = user.Encode(tmpFile, *users); err != nil {
-		return err
+// Update replaces the user that has the same ID as u.
+// If u is renamed to the name of another user, ErrNameTaken is returned.
+func (s *Storage) Update(u user.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := indexOf(s.users, u.ID)
+	if i < 0 {
+		return ErrNotFound
 	}
-	if err = tmpFile.Sync(); err != nil {
-		return err
+	if s.users[i].Name != u.Name {
+		if err := CheckName(s.users, u); err != nil {
+			return err
+		}
 	}
+	return s.apply(Op{Kind: OpUpdate, User: u})
+}
 
-	// Close both files: either the temp or the storage.
-	if err = tmpFile.Close(); err != nil {
-		return err
+// Delete deletes the user with the ID.
+func (s *Storage) Delete(id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if indexOf(s.users, id) < 0 {
+		return ErrNotFound
 	}
-	if err = s.Close(); err != nil {
+	return s.apply(Op{Kind: OpDelete, User: user.User{ID: id}})
+}
+
+// Swap is a change of a user made by Apply: New replaces Old. Nil Old means
+// New is added, and nil New means Old is deleted.
+type Swap struct {
+	Old, New *user.User
+}
+
+// Apply makes the swaps in order as one batch, so either all of them are made
+// or none is. If a user isn't the same as Old of its swap, or the ID of the added
+// user is taken, nothing is changed and ErrConflict is returned. If the added
+// or renamed user gets the name of another one, nothing is changed and ErrNameTaken
+// is returned. The added user with the zero ID gets a new ID, which is set in New.
+func (s *Storage) Apply(swaps ...Swap) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The swaps are checked against the users as changed by the previous swaps.
+	users := slices.Clone(s.users)
+	nextID := s.nextID
+	ops := make([]Op, 0, len(swaps))
+	ids := make([]uint64, len(swaps))
+	for k, sw := range swaps {
+		var op Op
+		switch {
+		case sw.Old == nil && sw.New == nil:
+			continue
+		case sw.Old == nil:
+			op = Op{Kind: OpAdd, User: *sw.New}
+			if op.User.ID == 0 {
+				op.User.ID = nextID
+			} else if indexOf(users, op.User.ID) >= 0 {
+				return ErrConflict
+			}
+			if err := CheckName(users, op.User); err != nil {
+				return err
+			}
+			nextID = nextIDAfter(nextID, []Op{op})
+			ids[k] = op.User.ID
+		default:
+			if i := indexOf(users, sw.Old.ID); i < 0 || !equal(users[i], *sw.Old) {
+				return ErrConflict
+			}
+			op = Op{Kind: OpDelete, User: user.User{ID: sw.Old.ID}}
+			if sw.New != nil {
+				op = Op{Kind: OpUpdate, User: *sw.New}
+				if sw.New.Name != sw.Old.Name {
+					if err := CheckName(users, op.User); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		users = replay(users, []Op{op})
+		ops = append(ops, op)
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	if err := s.apply(ops...); err != nil {
 		return err
 	}
+	for k, sw := range swaps {
+		if ids[k] != 0 {
+			sw.New.ID = ids[k]
+		}
+	}
+	return nil
+}
+
+// CheckName returns ErrNameTaken if a user other than u has the name of u.
+func CheckName(users []user.User, u user.User) error {
+	for _, other := range users {
+		if other.Name == u.Name && other.ID != u.ID {
+			return fmt.Errorf("%w: %q", ErrNameTaken, u.Name)
+		}
+	}
+	return nil
+}
+
+// equal reports whether the users are the same; no books are the same as the empty books.
+func equal(a, b user.User) bool {
+	return a.ID == b.ID && a.Name == b.Name && a.Age == b.Age && a.Active == b.Active &&
+		a.Mass == b.Mass && slices.Equal(a.Books, b.Books)
+}
 
-	// Rename (move) temporary into the main storage file.
-	if err = os.Rename(tmpFilePath, s.path); err != nil {
+// apply appends the operations to the log as one batch and applies them to the users.
+// It starts the compaction if the log has outgrown the threshold.
+func (s *Storage) apply(ops ...Op) error {
+	// Validate the batch before passing it to the backend.
+	batch, err := encodeBatch(ops)
+	if err != nil {
 		return err
 	}
-	if err = os.Chmod(s.path, fs.FileMode(filePerms)); err != nil {
+	if err = s.backend.Append(ops); err != nil {
 		return err
 	}
-	if err = s.Open(); err != nil {
+	s.logSize += int64(len(batch))
+	s.users = replay(s.users, ops)
+	s.nextID = nextIDAfter(s.nextID, ops)
+
+	if s.logSize > s.compactThreshold && !s.compacting {
+		s.compacting = true
+		s.compaction.Add(1)
+		go s.compact()
+	}
+	return nil
+}
+
+// compact saves the snapshot in the background.
+func (s *Storage) compact() {
+	defer s.compaction.Done()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.compacting = false
+	if err := s.saveSnapshot(); err != nil {
+		log.Println("storage: compaction failed:", err)
+	}
+}
+
+// Close waits for the background compaction and closes the backend.
+func (s *Storage) Close() error {
+	s.compaction.Wait()
+	return s.backend.Close()
+}
+
+// SaveSnapshot writes all the users to the snapshot and empties the log.
+func (s *Storage) SaveSnapshot() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saveSnapshot()
+}
+
+func (s *Storage) saveSnapshot() error {
+	if err := s.backend.Snapshot(s.users, s.nextID); err != nil {
 		return err
 	}
+	s.logSize = 0
 	return nil
 }
 
-func (s *Storage) Reader() io.Reader {
-	return s.file
+// backupBackend is implemented by the backends that keep the backups of the snapshot.
+type backupBackend interface {
+	Backups() ([]Backup, error)
+	ReadBackup(id string) ([]user.User, error)
 }
 
-func (s *Storage) Writer() io.Writer {
-	return s.file
+// Backups returns the backups of the snapshot, the newest first.
+// If the backend doesn't keep backups, ErrNoBackups is returned.
+func (s *Storage) Backups() ([]Backup, error) {
+	b, ok := s.backend.(backupBackend)
+	if !ok {
+		return nil, ErrNoBackups
+	}
+	return b.Backups()
 }
 
-func (s *Storage) Write(data []byte) (n int, err error) {
-	writer := bufio.NewWriter(s.Writer())
-	n, err = writer.Write(data)
+// Restore replaces the users with the ones of the backup with the ID.
+// The backup becomes the new snapshot, and the current users are kept
+// as the newest backup, so the restoring can be undone.
+func (s *Storage) Restore(id string) error {
+	b, ok := s.backend.(backupBackend)
+	if !ok {
+		return ErrNoBackups
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Read the backup first: saving the snapshots may remove it.
+	users, err := b.ReadBackup(id)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	if err = writer.Flush(); err != nil {
-		return 0, err
+	// Put the changes of the log into the snapshot, so they are backed up.
+	if s.logSize > 0 {
+		if err = s.saveSnapshot(); err != nil {
+			return err
+		}
+	}
+	// The IDs given after the backup was made aren't given again.
+	nextID := max(s.nextID, user.Slice(users).NextID())
+	if err = s.backend.Snapshot(users, nextID); err != nil {
+		return err
+	}
+	s.users, s.nextID = users, nextID
+	s.logSize = 0
+	return nil
+}
+
+// ReadOnly reports whether the storage rejects the changes.
+func (s *Storage) ReadOnly() bool {
+	if r, ok := s.backend.(interface{ ReadOnly() bool }); ok {
+		return r.ReadOnly()
 	}
-	return n, nil
+	return false
 }
 
-// Name returns the name of the file of the storage.
+// Name returns the name of the storage: the name of its file if the backend has one.
 func (s *Storage) Name() string {
-	_, name := filepath.Split(s.file.Name())
-	return name
+	if n, ok := s.backend.(interface{ Name() string }); ok {
+		return n.Name()
+	}
+	return "memory"
 }
@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"practice/internal/user"
+	"reflect"
+	"testing"
+)
+
+// backends returns the function that opens a backend for each backend type.
+// The file backends are reopened from the same temporary directory.
+func backends(t *testing.T) map[string]func() Backend {
+	dir := t.TempDir()
+	memory := NewMemory()
+	return map[string]func() Backend{
+		"Memory": func() Backend { return memory },
+		"File": func() Backend {
+			b, err := OpenFile(filepath.Join(dir, "test.database"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		},
+		"Segments": func() Backend {
+			b, err := OpenSegments(filepath.Join(dir, "segments"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		},
+	}
+}
+
+func load(t *testing.T, backend Backend) *Storage {
+	t.Helper()
+	strg := New(backend)
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return strg
+}
+
+func TestStorage_Replay(t *testing.T) {
+	for name, open := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			strg := load(t, open())
+
+			john, err := strg.Add(user.User{Name: "John Doe", Age: 30, Books: []string{"1984"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			jake, err := strg.Add(user.User{Name: "Jake Doe", Age: 20})
+			if err != nil {
+				t.Fatal(err)
+			}
+			john.Age = 31
+			if err = strg.Update(john); err != nil {
+				t.Fatal(err)
+			}
+			if err = strg.Delete(jake.ID); err != nil {
+				t.Fatal(err)
+			}
+			if err = strg.Delete(jake.ID); err != ErrNotFound {
+				t.Errorf("Delete() error = %v, want %v", err, ErrNotFound)
+			}
+			if err = strg.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// The users are restored from the log.
+			strg = load(t, open())
+			defer strg.Close()
+			want := []user.User{john}
+			if got := strg.Users(); !reflect.DeepEqual(got, want) {
+				t.Errorf("Users() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestStorage_Apply(t *testing.T) {
+	ann := user.User{ID: 1, Name: "Ann", Age: 30, Books: []string{"Dune"}}
+	bob := user.User{ID: 2, Name: "Bob"}
+	older := ann
+	older.Age = 31
+	bobNoBooks := bob
+	bobNoBooks.Books = []string{}
+
+	tests := []struct {
+		name    string
+		swaps   []Swap
+		wantErr error
+		want    []user.User
+	}{
+		{"update", []Swap{{&ann, &older}}, nil, []user.User{older, bob}},
+		{"update changed", []Swap{{&older, &ann}}, ErrConflict, []user.User{ann, bob}},
+		{"delete", []Swap{{&bob, nil}}, nil, []user.User{ann}},
+		{"delete empty books", []Swap{{&bobNoBooks, nil}}, nil, []user.User{ann}},
+		{"delete changed", []Swap{{&user.User{ID: 2, Name: "Rob"}, nil}}, ErrConflict, []user.User{ann, bob}},
+		{"delete missing", []Swap{{&user.User{ID: 3}, nil}}, ErrConflict, []user.User{ann, bob}},
+		{"add", []Swap{{nil, &user.User{ID: 5, Name: "Eve"}}}, nil, []user.User{ann, bob, {ID: 5, Name: "Eve"}}},
+		{"add existing ID", []Swap{{nil, &user.User{ID: 2, Name: "Eve"}}}, ErrConflict, []user.User{ann, bob}},
+		{"add new IDs", []Swap{{nil, &user.User{Name: "Eve"}}, {nil, &user.User{Name: "Dan"}}}, nil,
+			[]user.User{ann, bob, {ID: 3, Name: "Eve"}, {ID: 4, Name: "Dan"}}},
+		{"batch", []Swap{{&bob, nil}, {&ann, &older}, {nil, &bob}}, nil, []user.User{older, bob}},
+		{"batch conflict", []Swap{{&ann, &older}, {&bob, nil}, {&bob, nil}}, ErrConflict, []user.User{ann, bob}},
+		{"nothing", []Swap{{nil, nil}}, nil, []user.User{ann, bob}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strg := load(t, NewMemory(ann, bob))
+			defer strg.Close()
+			if err := strg.Apply(tt.swaps...); err != tt.wantErr {
+				t.Errorf("Apply() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := strg.Users(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Users() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStorage_NameTaken(t *testing.T) {
+	ann := user.User{ID: 1, Name: "Ann"}
+	bob := user.User{ID: 2, Name: "Bob"}
+	renamed := bob
+	renamed.Name = "Ann"
+	older := bob
+	older.Age = 30
+
+	tests := []struct {
+		name    string
+		change  func(strg *Storage) error
+		wantErr error
+	}{
+		{"Add", func(strg *Storage) error {
+			_, err := strg.Add(user.User{Name: "Ann"})
+			return err
+		}, ErrNameTaken},
+		{"Update", func(strg *Storage) error { return strg.Update(renamed) }, ErrNameTaken},
+		{"Update keeping name", func(strg *Storage) error { return strg.Update(older) }, nil},
+		{"Apply add", func(strg *Storage) error { return strg.Apply(Swap{New: &user.User{Name: "Bob"}}) }, ErrNameTaken},
+		{"Apply rename", func(strg *Storage) error { return strg.Apply(Swap{Old: &bob, New: &renamed}) }, ErrNameTaken},
+		{"Apply batch", func(strg *Storage) error {
+			return strg.Apply(Swap{Old: &ann, New: &user.User{ID: 1, Name: "Bob"}}, Swap{Old: &bob, New: &renamed})
+		}, ErrNameTaken},
+		{"Apply after delete", func(strg *Storage) error {
+			return strg.Apply(Swap{Old: &ann}, Swap{Old: &bob, New: &renamed})
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strg := load(t, NewMemory(ann, bob))
+			defer strg.Close()
+			before := strg.Users()
+			err := tt.change(strg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil && !reflect.DeepEqual(strg.Users(), before) {
+				t.Errorf("Users() = %+v, want %+v", strg.Users(), before)
+			}
+		})
+	}
+}
+
+func TestStorage_Compaction(t *testing.T) {
+	for name, open := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			strg := load(t, open())
+			strg.compactThreshold = 512
+
+			for i := 0; i < 100; i++ {
+				if _, err := strg.Add(user.User{Name: fmt.Sprintf("User %d", i), Age: uint8(i)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want := strg.Users()
+			if err := strg.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			backend := open()
+			_, _, ops, err := backend.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ops) > 50 {
+				t.Errorf("log has %d operations, want it to be compacted", len(ops))
+			}
+			strg = load(t, backend)
+			defer strg.Close()
+			if got := strg.Users(); !reflect.DeepEqual(got, want) {
+				t.Errorf("Users() returned %d users, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestStorage_NextID(t *testing.T) {
+	for name, open := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			strg := load(t, open())
+			john, _ := strg.Add(user.User{Name: "John Doe"})
+			jake, _ := strg.Add(user.User{Name: "Jake Doe"})
+			if err := strg.Delete(jake.ID); err != nil {
+				t.Fatal(err)
+			}
+			jane, _ := strg.Add(user.User{Name: "Jane Doe"})
+			if jane.ID != jake.ID+1 {
+				t.Errorf("Add() after Delete() ID = %d, want %d", jane.ID, jake.ID+1)
+			}
+			if err := strg.Delete(jane.ID); err != nil {
+				t.Fatal(err)
+			}
+			// Both the deletion in the log and the one in the snapshot are loaded.
+			for _, save := range []bool{false, true} {
+				if save {
+					if err := strg.SaveSnapshot(); err != nil {
+						t.Fatal(err)
+					}
+				}
+				strg.Close()
+				strg = load(t, open())
+				if got := strg.Users(); !reflect.DeepEqual(got, []user.User{john}) {
+					t.Errorf("Users() = %+v, want %+v", got, []user.User{john})
+				}
+				if id := strg.NextID(); id != jane.ID+1 {
+					t.Errorf("NextID() after load = %d, want %d", id, jane.ID+1)
+				}
+			}
+			strg.Close()
+		})
+	}
+}
+
+func TestStorage_TooLarge(t *testing.T) {
+	strg := load(t, NewMemory())
+	_, err := strg.Add(user.User{Name: string(make([]byte, user.MaxRecordSize))})
+	if err == nil {
+		t.Errorf("Add() error = nil, want an error")
+	}
+	if n := len(strg.Users()); n != 0 {
+		t.Errorf("Users() returned %d users, want 0", n)
+	}
+}
+
+func TestReplay_Idempotent(t *testing.T) {
+	ops := []Op{
+		{Kind: OpAdd, User: user.User{ID: 1, Name: "John"}},
+		{Kind: OpAdd, User: user.User{ID: 2, Name: "Jake"}},
+		{Kind: OpUpdate, User: user.User{ID: 1, Name: "John Doe"}},
+		{Kind: OpDelete, User: user.User{ID: 2}},
+	}
+	once := replay(nil, ops)
+	twice := replay(replay(nil, ops), ops)
+	want := []user.User{{ID: 1, Name: "John Doe"}}
+	if !reflect.DeepEqual(once, want) {
+		t.Errorf("replay() = %+v, want %+v", once, want)
+	}
+	if !reflect.DeepEqual(twice, want) {
+		t.Errorf("replay() twice = %+v, want %+v", twice, want)
+	}
+}
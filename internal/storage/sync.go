@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// syncer commits the written log files to the disk according to the policy.
+type syncer struct {
+	policy SyncPolicy
+
+	mu    sync.Mutex
+	file  *os.File // the last written file
+	dirty bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newSyncer returns a new syncer. For SyncEvery, it starts committing
+// the written file in the background.
+func newSyncer(policy SyncPolicy) *syncer {
+	s := &syncer{policy: policy}
+	if policy != SyncAlways && policy != SyncNever {
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.loop()
+	}
+	return s
+}
+
+func (s *syncer) loop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.policy.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.flush(); err != nil {
+				log.Println("storage: failed to sync the log:", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// written is called after the data is written to the file.
+// With SyncAlways, it commits the file right away.
+func (s *syncer) written(file *os.File) error {
+	switch s.policy {
+	case SyncAlways:
+		return file.Sync()
+	case SyncNever:
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dirty && s.file != file {
+		if err := s.file.Sync(); err != nil {
+			return err
+		}
+	}
+	s.file, s.dirty = file, true
+	return nil
+}
+
+// flush commits the last written file if it has the uncommitted data.
+// It has to be called before the file is closed.
+func (s *syncer) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	s.dirty = false
+	return s.file.Sync()
+}
+
+// close stops the background commits and commits the rest of the data.
+func (s *syncer) close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+	return s.flush()
+}
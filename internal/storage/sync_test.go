@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"practice/internal/user"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestFileBackend_Crash cuts the log at every offset, as if the program stopped
+// in the middle of a write, and checks that the database is loaded with all
+// the batches written completely before the offset.
+func TestFileBackend_Crash(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.database")
+	backend, err := OpenFile(path, WithSync(SyncAlways))
+	if err != nil {
+		t.Fatal(err)
+	}
+	strg := load(t, backend)
+	strg.compactThreshold = 1 << 20
+
+	// states[i] are the users after the log of ends[i] bytes is replayed.
+	var states [][]user.User
+	var ends []int64
+	logged := func() {
+		info, err := os.Stat(path + logExt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, strg.Users())
+		ends = append(ends, info.Size())
+	}
+	logged()
+	for _, name := range []string{"John Doe", "Jake Doe", "Jane Doe"} {
+		if _, err = strg.Add(user.User{Name: name, Age: 30, Books: []string{"Go"}}); err != nil {
+			t.Fatal(err)
+		}
+		logged()
+	}
+	if err = strg.Update(user.User{ID: 2, Name: "Jake Doe", Age: 31}); err != nil {
+		t.Fatal(err)
+	}
+	logged()
+	if err = strg.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+	logged()
+	strg.Close()
+
+	snapshot, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := os.ReadFile(path + logExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k := int64(0); k <= int64(len(log)); k++ {
+		crashed := filepath.Join(t.TempDir(), "test.database")
+		if err = os.WriteFile(crashed, snapshot, filePerms); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(crashed+logExt, log[:k], filePerms); err != nil {
+			t.Fatal(err)
+		}
+
+		want := states[0]
+		for i, end := range ends {
+			if end <= k {
+				want = states[i]
+			}
+		}
+		backend, err := OpenFile(crashed)
+		if err != nil {
+			t.Fatalf("offset %d: OpenFile() error = %v", k, err)
+		}
+		strg := New(backend)
+		if err = strg.Load(); err != nil {
+			t.Fatalf("offset %d: Load() error = %v", k, err)
+		}
+		if got := strg.Users(); !reflect.DeepEqual(got, want) {
+			t.Errorf("offset %d: Users() = %+v, want %+v", k, got, want)
+		}
+		// The database stays writable after the crash.
+		if _, err = strg.Add(user.User{Name: "Jill Doe"}); err != nil {
+			t.Errorf("offset %d: Add() error = %v", k, err)
+		}
+		strg.Close()
+	}
+}
+
+// TestFileBackend_CrashSnapshot simulates the crash after the snapshot is replaced
+// but before the log is emptied.
+func TestFileBackend_CrashSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.database")
+	backend, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strg := load(t, backend)
+	for _, name := range []string{"John Doe", "Jake Doe"} {
+		if _, err = strg.Add(user.User{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = strg.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+	want := strg.Users()
+	log, err := os.ReadFile(path + logExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = strg.SaveSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	strg.Close()
+	if err = os.WriteFile(path+logExt, log, filePerms); err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strg = load(t, backend)
+	defer strg.Close()
+	if got := strg.Users(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Users() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSyncer(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "test.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	tests := []struct {
+		name   string
+		policy SyncPolicy
+		dirty  bool // whether the write is left uncommitted
+	}{
+		{"always", SyncAlways, false},
+		{"never", SyncNever, false},
+		{"every", SyncEvery(time.Hour), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSyncer(tt.policy)
+			if err := s.written(file); err != nil {
+				t.Fatal(err)
+			}
+			if s.dirty != tt.dirty {
+				t.Errorf("dirty = %v, want %v", s.dirty, tt.dirty)
+			}
+			if err := s.close(); err != nil {
+				t.Fatal(err)
+			}
+			if s.dirty {
+				t.Error("close() left the write uncommitted")
+			}
+		})
+	}
+
+	// The background commit.
+	s := newSyncer(SyncEvery(time.Millisecond))
+	defer s.close()
+	if err := s.written(file); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.mu.Lock()
+		dirty := s.dirty
+		s.mu.Unlock()
+		if !dirty {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the write isn't committed in the background")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSyncPolicy_String(t *testing.T) {
+	tests := []struct {
+		policy SyncPolicy
+		want   string
+	}{
+		{SyncAlways, "always"},
+		{SyncNever, "never"},
+		{SyncEvery(100 * time.Millisecond), "every 100ms"},
+		{SyncEvery(0), "always"},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    SyncPolicy
+		wantErr bool
+	}{
+		{"always", SyncAlways, false},
+		{"never", SyncNever, false},
+		{"250ms", SyncEvery(250 * time.Millisecond), false},
+		{"0s", SyncPolicy{}, true},
+		{"sometimes", SyncPolicy{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSyncPolicy(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseSyncPolicy(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+// Write-ahead log of the storage.
+// Schema:
+//
+//	Header             LogMagic + user.Header of the records
+//	Batches...         each batch is a frame (see user.AppendFrame) of:
+//	                   uvarint(number of operations) + operations
+//
+// Operation:
+//
+//	Kind               uint8
+//	Record             uvarint(length) + [length]byte: user record payload
+//	                   (see user.MarshalUser); for OpDelete only the ID matters
+//
+// A batch is written in one Write call, so it is applied either entirely or not at all.
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"practice/internal/user"
+)
+
+// OpKind is the kind of change made to the users.
+type OpKind uint8
+
+const (
+	OpAdd OpKind = iota + 1
+	OpUpdate
+	OpDelete
+)
+
+func (k OpKind) String() string {
+	switch k {
+	case OpAdd:
+		return "add"
+	case OpUpdate:
+		return "update"
+	case OpDelete:
+		return "delete"
+	}
+	return fmt.Sprintf("OpKind(%d)", uint8(k))
+}
+
+// Op is a single change made to the users.
+type Op struct {
+	Kind OpKind
+	User user.User
+}
+
+// LogMagic is the sequence of bytes the log file starts with.
+var LogMagic = [8]byte{0x89, 'U', 'S', 'R', 'L', 'O', 'G', '\n'}
+
+// logHeaderSize is the size of the magic bytes and the header of the log.
+var logHeaderSize = int64(len(LogMagic) + binary.Size(user.CurrentHeader))
+
+var ErrBadLog = errors.New("not a log file")
+
+// writeLogHeader writes the magic bytes and the header of the current format.
+func writeLogHeader(w io.Writer) (err error) {
+	if err = binary.Write(w, binary.BigEndian, LogMagic); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, user.CurrentHeader)
+}
+
+// encodeBatch returns the frame of the batch of the operations.
+func encodeBatch(ops []Op) ([]byte, error) {
+	payload := binary.AppendUvarint(nil, uint64(len(ops)))
+	for _, op := range ops {
+		record, err := user.MarshalUser(op.User)
+		if err != nil {
+			return nil, err
+		}
+		payload = append(payload, byte(op.Kind))
+		payload = binary.AppendUvarint(payload, uint64(len(record)))
+		payload = append(payload, record...)
+	}
+	if len(payload) > user.MaxRecordSize {
+		return nil, user.ErrRecordTooLarge
+	}
+	return user.AppendFrame(nil, payload), nil
+}
+
+// batchSize returns the size of the encoded batch of the operations.
+func batchSize(ops []Op) int64 {
+	if len(ops) == 0 {
+		return 0
+	}
+	batch, _ := encodeBatch(ops)
+	return int64(len(batch))
+}
+
+// openLog opens the log file at the path. A new log file gets the header;
+// if it isn't committed, the header is written again on the next opening.
+func openLog(path string, perms fs.FileMode) (*os.File, error) {
+	flags := os.O_CREATE | os.O_RDWR | os.O_APPEND
+	file, err := os.OpenFile(path, flags, perms)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err == nil && info.Size() == 0 {
+		err = resetLog(file)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// readLog reads the operations of the log file. If truncate is set, the torn tail
+// of the log is cut off, so new batches are appended after the valid part of the log.
+func readLog(file *os.File, truncate bool) ([]Op, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < logHeaderSize {
+		// The log was being created when the program stopped.
+		if truncate && info.Size() > 0 {
+			err = resetLog(file)
+		}
+		return nil, err
+	}
+	ops, size, err := decodeLog(file)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read log %s: %w", file.Name(), err)
+	}
+	if size < info.Size() && truncate {
+		log.Printf("storage: dropping %d bytes of the torn tail of %s", info.Size()-size, file.Name())
+		if err = file.Truncate(size); err != nil {
+			return nil, err
+		}
+	}
+	return ops, nil
+}
+
+// resetLog empties the log file. Committing it to the disk is up to the caller.
+func resetLog(file *os.File) (err error) {
+	if err = file.Truncate(0); err != nil {
+		return err
+	}
+	return writeLogHeader(file)
+}
+
+// appendLog appends the batch of the operations to the log file in one Write call.
+// Committing it to the disk is up to the caller.
+func appendLog(file *os.File, ops []Op) error {
+	batch, err := encodeBatch(ops)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(batch)
+	return err
+}
+
+// decodeLog reads all the batches of the log. A torn or damaged batch
+// at the end of the log (e.g. after a crash) and everything after it are ignored;
+// the returned size is the size of the valid part of the log.
+func decodeLog(r io.Reader) (ops []Op, size int64, err error) {
+	rb := bufio.NewReader(r)
+	var magic [8]byte
+	if err = binary.Read(rb, binary.BigEndian, &magic); err != nil || magic != LogMagic {
+		return nil, 0, ErrBadLog
+	}
+	var h user.Header
+	if err = binary.Read(rb, binary.BigEndian, &h); err != nil {
+		return nil, 0, ErrBadLog
+	}
+	if err = h.Validate(); err != nil {
+		return nil, 0, err
+	}
+	data, err := io.ReadAll(rb)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	size = logHeaderSize
+	for len(data) > 0 {
+		payload, n, ok := user.ReadFrame(data)
+		if !ok {
+			break
+		}
+		batch, err := decodeBatch(payload, h.Flags)
+		if err != nil {
+			break
+		}
+		ops = append(ops, batch...)
+		data = data[n:]
+		size += int64(n)
+	}
+	return ops, size, nil
+}
+
+func decodeBatch(payload []byte, flags uint16) (ops []Op, err error) {
+	r := bytes.NewReader(payload)
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < count; i++ {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if length > uint64(r.Len()) {
+			return nil, user.ErrInvalidLength
+		}
+		record := make([]byte, length)
+		if _, err = io.ReadFull(r, record); err != nil {
+			return nil, err
+		}
+		u, err := user.UnmarshalUser(record, flags)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, Op{Kind: OpKind(kind), User: u})
+	}
+	return ops, nil
+}
+
+// replay applies the operations to the users. Replaying is idempotent: adding
+// or updating an existing user replaces them, and deleting a missing user
+// does nothing. So the log may be replayed over the snapshot that already
+// contains its changes.
+func replay(users []user.User, ops []Op) []user.User {
+	for _, op := range ops {
+		i := indexOf(users, op.User.ID)
+		switch {
+		case op.Kind == OpDelete && i >= 0:
+			users = append(users[:i], users[i+1:]...)
+		case op.Kind == OpDelete:
+		case i >= 0:
+			users[i] = op.User
+		default:
+			users = append(users, op.User)
+		}
+	}
+	return users
+}
+
+// nextIDAfter returns the ID for the next new user after the operations:
+// it is above the IDs of the users they put.
+func nextIDAfter(nextID uint64, ops []Op) uint64 {
+	for _, op := range ops {
+		if op.Kind != OpDelete && op.User.ID >= nextID {
+			nextID = op.User.ID + 1
+		}
+	}
+	return nextID
+}
+
+// indexOf returns the index of the user with the ID, or -1 if there is no such user.
+func indexOf(users []user.User, id uint64) int {
+	for i, u := range users {
+		if u.ID == id {
+			return i
+		}
+	}
+	return -1
+}
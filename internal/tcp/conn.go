@@ -0,0 +1,293 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"practice/internal/auth"
+	"practice/internal/storage"
+	"practice/internal/tui"
+	"practice/internal/user"
+	"sync"
+	"time"
+)
+
+// ErrNotSent is wrapped by the error of the request that has failed before it is
+// sent to the server, so the server hasn't done it.
+var ErrNotSent = errors.New("request is not sent")
+
+// Conn is a session with the server in the JSON protocol (see tui.Request).
+// It is safe for concurrent use; the requests are sent one at a time.
+// The errors returned by the server are *tui.Error.
+type Conn struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	r      *bufio.Reader
+	login  bool // the session needs logging in
+	broken bool // a request failed on the connection, so the session is out of sync
+}
+
+// Dial connects to the server at the address and switches the session to
+// the JSON protocol. If the server has the users, the session needs Login.
+// Only the WithTLS option applies.
+func Dial(ctx context.Context, addr string, opts ...Option) (*Conn, error) {
+	conn, err := dial(ctx, addr, newConfig(opts))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to server: %w", err)
+	}
+	c := &Conn{conn: conn, r: bufio.NewReader(conn)}
+	if err := c.handshake(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// handshake sends "proto json" and skips the greeting of the prompt up to the response.
+func (c *Conn) handshake(ctx context.Context) error {
+	defer c.watch(ctx)()
+	if _, err := io.WriteString(c.conn, "proto json\n"); err != nil {
+		return fmt.Errorf("handshake: %w", err)
+	}
+	var last []byte
+	for {
+		line, err := c.r.ReadBytes('\n')
+		if err != nil {
+			if len(bytes.TrimSpace(last)) > 0 {
+				// The server refused the session, like when it is busy.
+				return fmt.Errorf("handshake: %s", bytes.TrimSpace(last))
+			}
+			return fmt.Errorf("handshake: %w", c.ctxErr(ctx, err))
+		}
+		if !bytes.HasPrefix(line, []byte("{")) {
+			last = line
+			continue
+		}
+		var hs tui.Handshake
+		if err := decodeResponse(line, &hs); err != nil {
+			return fmt.Errorf("handshake: %w", err)
+		}
+		c.login = hs.Login
+		return nil
+	}
+}
+
+// watch applies the deadline of ctx to the connection and interrupts it when
+// ctx is canceled. The returned function stops watching.
+func (c *Conn) watch(ctx context.Context) func() {
+	deadline, _ := ctx.Deadline()
+	c.conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() {
+		c.conn.SetDeadline(time.Now())
+	})
+	return func() {
+		stop()
+		c.conn.SetDeadline(time.Time{})
+	}
+}
+
+// ctxErr returns the error of ctx if the connection is interrupted by it.
+func (c *Conn) ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// The deadline of the connection may pass a bit earlier than the one of ctx.
+	if deadline, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// Do sends the request and decodes the data of the response into data
+// unless it is nil. If the request fails on the connection, the connection
+// can't be used anymore, and the next requests fail with net.ErrClosed.
+// If the request isn't sent, the error wraps ErrNotSent.
+func (c *Conn) Do(ctx context.Context, cmd string, args map[string]any, data any) error {
+	req, err := json.Marshal(tui.Request{Cmd: cmd, Args: args})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.broken {
+		return fmt.Errorf("%w: %w", ErrNotSent, net.ErrClosed)
+	}
+	defer c.watch(ctx)()
+	if n, err := c.conn.Write(append(req, '\n')); err != nil {
+		c.broken = true
+		if n == 0 {
+			err = fmt.Errorf("%w: %w", ErrNotSent, err)
+		}
+		return c.ctxErr(ctx, err)
+	}
+	line, err := c.r.ReadBytes('\n')
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		c.broken = true
+		return c.ctxErr(ctx, err)
+	}
+	return decodeResponse(line, data)
+}
+
+// decodeResponse decodes the response line and the data of it.
+func decodeResponse(line []byte, data any) error {
+	var res struct {
+		Status string          `json:"status"`
+		Data   json.RawMessage `json:"data"`
+		Error  *tui.Error      `json:"error"`
+	}
+	if err := json.Unmarshal(line, &res); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	if res.Status != tui.StatusOK {
+		if res.Error == nil {
+			return fmt.Errorf("invalid response status %q", res.Status)
+		}
+		return res.Error
+	}
+	if data == nil || len(res.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(res.Data, data); err != nil {
+		return fmt.Errorf("invalid response data: %w", err)
+	}
+	return nil
+}
+
+// NeedsLogin reports whether the server has the users, so the session needs Login.
+func (c *Conn) NeedsLogin() bool {
+	return c.login
+}
+
+// Closed reports whether the session has ended, like when the server has closed
+// it while it was idle. It doesn't wait for the server.
+func (c *Conn) Closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Nothing is expected from the idle session, so anything on the connection,
+	// like EOF or the shutdown message, means it has ended.
+	conn := c.conn
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	if c.r.Buffered() > 0 || peerDone(conn) {
+		c.broken = true
+	}
+	return c.broken
+}
+
+// Login logs in and returns the role of the user.
+func (c *Conn) Login(ctx context.Context, name, password string) (auth.Role, error) {
+	var res tui.Login
+	if err := c.Do(ctx, "login", map[string]any{"name": name, "password": password}, &res); err != nil {
+		return 0, err
+	}
+	return auth.ParseRole(res.Role)
+}
+
+// Users returns the users of the database.
+func (c *Conn) Users(ctx context.Context) ([]user.User, error) {
+	var users []user.User
+	err := c.Do(ctx, "show", nil, &users)
+	return users, err
+}
+
+// FindByName returns the user with the name.
+func (c *Conn) FindByName(ctx context.Context, name string) (user.User, error) {
+	var u user.User
+	err := c.Do(ctx, "get", map[string]any{"name": name}, &u)
+	return u, err
+}
+
+// AvgAgePerBook returns the average age of the readers per book, the oldest first.
+func (c *Conn) AvgAgePerBook(ctx context.Context) ([]user.AvgAgePerBook, error) {
+	var books []user.AvgAgePerBook
+	err := c.Do(ctx, "books", nil, &books)
+	return books, err
+}
+
+// Add adds the user and returns them with the ID.
+func (c *Conn) Add(ctx context.Context, u user.User) (user.User, error) {
+	args := map[string]any{
+		"name":   u.Name,
+		"age":    u.Age,
+		"active": u.Active,
+		"mass":   u.Mass,
+	}
+	if len(u.Books) > 0 {
+		args["books"] = u.Books
+	}
+	var added user.User
+	err := c.Do(ctx, "add", args, &added)
+	return added, err
+}
+
+// Edit changes the user with the name and returns them. The arguments are
+// the same as of the "edit" command, like "age" or "add_books".
+func (c *Conn) Edit(ctx context.Context, name string, args map[string]any) (user.User, error) {
+	all := map[string]any{"name": name}
+	for k, v := range args {
+		all[k] = v
+	}
+	var u user.User
+	err := c.Do(ctx, "edit", all, &u)
+	return u, err
+}
+
+// Remove removes the user with the name.
+func (c *Conn) Remove(ctx context.Context, name string) error {
+	return c.Do(ctx, "remove", map[string]any{"name": name}, nil)
+}
+
+// Backups returns the backups of the database, the newest first.
+func (c *Conn) Backups(ctx context.Context) ([]storage.Backup, error) {
+	var backups []storage.Backup
+	err := c.Do(ctx, "backups", nil, &backups)
+	return backups, err
+}
+
+// Restore replaces the users with the backup.
+func (c *Conn) Restore(ctx context.Context, id string) error {
+	return c.Do(ctx, "restore", map[string]any{"id": id}, nil)
+}
+
+// Begin begins a transaction: the changes of the session are seen by it only,
+// until Commit applies them at once.
+func (c *Conn) Begin(ctx context.Context) error {
+	return c.Do(ctx, "begin", nil, nil)
+}
+
+// Commit applies the changes of the transaction and returns the number of the
+// users changed. If another session has changed the same users since Begin,
+// nothing is applied and the error has the code tui.CodeConflict.
+func (c *Conn) Commit(ctx context.Context) (int, error) {
+	var res struct {
+		Changes int `json:"changes"`
+	}
+	err := c.Do(ctx, "commit", nil, &res)
+	return res.Changes, err
+}
+
+// Rollback discards the changes of the transaction.
+func (c *Conn) Rollback(ctx context.Context) error {
+	return c.Do(ctx, "rollback", nil, nil)
+}
+
+// Close ends the session and closes the connection.
+func (c *Conn) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	// The server may have ended the session already; it doesn't matter.
+	c.Do(ctx, "quit", nil, nil)
+	return c.conn.Close()
+}
@@ -0,0 +1,269 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"practice/internal/auth"
+	"practice/internal/storage"
+	"practice/internal/tui"
+	"practice/internal/user"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// errCode returns the code of the error returned by the server.
+func errCode(err error) string {
+	var e *tui.Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+func TestConn(t *testing.T) {
+	strg := storage.New(storage.NewMemory(user.User{ID: 1, Name: "John Doe", Age: 30}))
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	addr := startServer(t, strg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.NeedsLogin() {
+		t.Error("NeedsLogin() = true, want false")
+	}
+
+	added, err := c.Add(ctx, user.User{Name: "Ann", Age: 25, Active: true, Mass: 61.5, Books: []string{"Dune", "Emma"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := user.User{ID: 2, Name: "Ann", Age: 25, Active: true, Mass: 61.5, Books: []string{"Dune", "Emma"}}
+	if !reflect.DeepEqual(added, want) {
+		t.Errorf("Add() = %+v, want %+v", added, want)
+	}
+	if _, err = c.Add(ctx, user.User{Name: " "}); errCode(err) != tui.CodeBadRequest {
+		t.Errorf("Add(no name) error = %v, want code %s", err, tui.CodeBadRequest)
+	}
+
+	if err = c.Remove(ctx, "John Doe"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Remove(ctx, "John Doe"); errCode(err) != tui.CodeNotFound {
+		t.Errorf("Remove(removed) error = %v, want code %s", err, tui.CodeNotFound)
+	}
+	users, err := c.Users(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(users, []user.User{want}) {
+		t.Errorf("Users() = %+v, want %+v", users, []user.User{want})
+	}
+
+	edited, err := c.Edit(ctx, "Ann", map[string]any{"age": 26, "remove_books": []string{"Dune"}})
+	want.Age, want.Books = 26, []string{"Emma"}
+	if err != nil || !reflect.DeepEqual(edited, want) {
+		t.Errorf("Edit() = %+v, %v, want %+v", edited, err, want)
+	}
+
+	// The memory doesn't keep backups.
+	if _, err = c.Backups(ctx); errCode(err) != tui.CodeNotFound {
+		t.Errorf("Backups() error = %v, want code %s", err, tui.CodeNotFound)
+	}
+	if err = c.Restore(ctx, "20240101-000000.000000"); errCode(err) != tui.CodeNotFound {
+		t.Errorf("Restore() error = %v, want code %s", err, tui.CodeNotFound)
+	}
+	if err = c.Do(ctx, "drop", nil, nil); errCode(err) != tui.CodeUnknownCommand {
+		t.Errorf("Do(drop) error = %v, want code %s", err, tui.CodeUnknownCommand)
+	}
+}
+
+func TestConn_Login(t *testing.T) {
+	users := auth.NewStore()
+	users.Set("admin", "secret", auth.RoleWrite)
+	users.Set("guest", "guest", auth.RoleRead)
+	strg := storage.New(storage.NewMemory(user.User{ID: 1, Name: "John Doe"}))
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	addr := startServer(t, strg, WithUsers(users))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if !c.NeedsLogin() {
+		t.Error("NeedsLogin() = false, want true")
+	}
+
+	if _, err = c.Users(ctx); errCode(err) != tui.CodeUnauthorized {
+		t.Errorf("Users() before login error = %v, want code %s", err, tui.CodeUnauthorized)
+	}
+	if _, err = c.Login(ctx, "guest", "admin"); errCode(err) != tui.CodeUnauthorized {
+		t.Errorf("Login(wrong password) error = %v, want code %s", err, tui.CodeUnauthorized)
+	}
+	role, err := c.Login(ctx, "guest", "guest")
+	if err != nil || role != auth.RoleRead {
+		t.Fatalf("Login() = %v, %v, want %v", role, err, auth.RoleRead)
+	}
+	if users, err := c.Users(ctx); err != nil || len(users) != 1 {
+		t.Errorf("Users() = %v, %v, want 1 user", users, err)
+	}
+	if err = c.Remove(ctx, "John Doe"); errCode(err) != tui.CodeForbidden {
+		t.Errorf("Remove() error = %v, want code %s", err, tui.CodeForbidden)
+	}
+}
+
+func TestServer_ProtoJSON(t *testing.T) {
+	strg := storage.New(storage.NewMemory())
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	addr := startServer(t, strg)
+
+	input := strings.Join([]string{
+		"show",
+		"PROTO json",
+		`{"cmd": "add", "args": {"name": "Ann", "age": 25, "active": true, "books": ["Dune"]}}`,
+		`{"cmd": "add", "args": {"name": "Bob", "age": "old"}}`,
+		`{"cmd": "add", "args": {"name": {}}}`,
+		`not json`,
+		`{"cmd": "quit"}`,
+		"",
+	}, "\n")
+	out, err := session(addr, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The prompt is left before the handshake.
+	_, out, ok := strings.Cut(out, "Number of active users: 0\n")
+	if !ok {
+		t.Fatalf("no output of show:\n%s", out)
+	}
+	want := []string{
+		`{"status":"ok","data":{"proto":"json","login":false},"error":null}`,
+		`{"status":"ok","data":{"id":1,"name":"Ann","age":25,"active":true,"mass":0,"books":["Dune"]},"error":null}`,
+		`{"status":"error","data":null,"error":{"code":"bad_request","message":"invalid age \"old\", want a number from 0 to 255"}}`,
+		`{"status":"error","data":null,"error":{"code":"bad_request","message":"invalid argument \"name\""}}`,
+		`{"status":"error","data":null,"error":{"code":"bad_request","message":"invalid request: invalid character 'o' in literal null (expecting 'u')"}}`,
+		`{"status":"ok","data":null,"error":null}`,
+	}
+	got := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(got) < 1 || !strings.HasSuffix(got[0], " > ") {
+		t.Fatalf("no prompt before handshake:\n%s", out)
+	}
+	if !reflect.DeepEqual(got[1:], want) {
+		t.Errorf("responses:\n%s\nwant:\n%s", strings.Join(got[1:], "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestDial_Busy(t *testing.T) {
+	strg := storage.New(storage.NewMemory())
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	addr := startServer(t, strg, WithMaxConns(1))
+
+	conn, _, err := dialGreeting(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = Dial(ctx, addr)
+	if err == nil || !strings.Contains(err.Error(), busyMessage) {
+		t.Errorf("Dial() error = %v, want %q", err, busyMessage)
+	}
+}
+
+func TestConn_Transaction(t *testing.T) {
+	ann := user.User{ID: 1, Name: "Ann", Age: 30}
+	strg := storage.New(storage.NewMemory(ann))
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	addr := startServer(t, strg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	a, err := Dial(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := Dial(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	// The other session doesn't see the changes until commit.
+	if err = a.Begin(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Begin(ctx); errCode(err) != tui.CodeBadRequest {
+		t.Errorf("Begin() again error = %v, want code %s", err, tui.CodeBadRequest)
+	}
+	if _, err = a.Add(ctx, user.User{Name: "Bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Remove(ctx, "Ann"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := a.Users(ctx); err != nil || len(got) != 1 || got[0].Name != "Bob" {
+		t.Errorf("Users() in the transaction = %+v, %v, want Bob", got, err)
+	}
+	if got, err := b.Users(ctx); err != nil || !reflect.DeepEqual(got, []user.User{ann}) {
+		t.Errorf("Users() of the other session = %+v, %v, want %+v", got, err, []user.User{ann})
+	}
+	if _, err = b.FindByName(ctx, "Bob"); errCode(err) != tui.CodeNotFound {
+		t.Errorf("FindByName(Bob) of the other session error = %v, want code %s", err, tui.CodeNotFound)
+	}
+	if n, err := a.Commit(ctx); err != nil || n != 2 {
+		t.Errorf("Commit() = %d, %v, want 2 changes", n, err)
+	}
+	want := []user.User{{ID: 2, Name: "Bob"}} // the ID of Ann isn't given again
+	if got, err := b.Users(ctx); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Users() after commit = %+v, %v, want %+v", got, err, want)
+	}
+
+	// The transaction changing the users changed by the other session since is rolled back.
+	if err = a.Begin(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.Add(ctx, user.User{Name: "Carl"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.Edit(ctx, "Bob", map[string]any{"age": 40}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.Edit(ctx, "Bob", map[string]any{"age": 50}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.Commit(ctx); errCode(err) != tui.CodeConflict {
+		t.Errorf("Commit() error = %v, want code %s", err, tui.CodeConflict)
+	}
+	want = []user.User{{ID: 2, Name: "Bob", Age: 50}}
+	if got := strg.Users(); !reflect.DeepEqual(got, want) {
+		t.Errorf("users after the conflict = %+v, want %+v", got, want)
+	}
+	if err = a.Rollback(ctx); errCode(err) != tui.CodeBadRequest {
+		t.Errorf("Rollback() without transaction error = %v, want code %s", err, tui.CodeBadRequest)
+	}
+}
@@ -0,0 +1,109 @@
+package tcp
+
+import (
+	"net"
+	"practice/internal/tui"
+	"sync"
+	"time"
+)
+
+// maxIdleBuckets is the number of the buckets of rateLimiter after which
+// the full ones are dropped.
+const maxIdleBuckets = 1024
+
+// rateLimiter limits the rate of the commands from every IP address
+// with a token bucket per address. It is safe for concurrent use.
+type rateLimiter struct {
+	rate  float64 // the tokens added per second
+	burst float64 // the size of the bucket
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), now: time.Now, buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token from the bucket of the address. It reports false
+// if the bucket is empty.
+func (l *rateLimiter) allow(addr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	b, ok := l.buckets[addr]
+	if !ok {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.prune(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[addr] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune drops the buckets that are full by now, as they are the same as the new ones.
+func (l *rateLimiter) prune(now time.Time) {
+	for addr, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, addr)
+		}
+	}
+}
+
+// connLimits apply the deadlines and the rate limit of the server to the session
+// of the connection. They implement tui.Limits.
+type connLimits struct {
+	conn net.Conn
+	cfg  *config
+	rate *rateLimiter // nil if there is no limit
+	host string
+}
+
+func (s *server) limits(conn net.Conn) *connLimits {
+	return &connLimits{conn: conn, cfg: &s.cfg, rate: s.rate, host: remoteHost(conn)}
+}
+
+// remoteHost returns the IP address of the client of the connection.
+func remoteHost(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+func (l *connLimits) Wait() {
+	l.conn.SetDeadline(deadline(l.cfg.idleTimeout))
+}
+
+func (l *connLimits) Command(name string) error {
+	if l.rate != nil && !l.rate.allow(l.host) {
+		return tui.ErrTooManyCommands
+	}
+	l.conn.SetDeadline(deadline(l.cfg.commandTimeout))
+	return nil
+}
+
+// deadline returns the deadline in d from now, or no deadline if d is zero.
+func deadline(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
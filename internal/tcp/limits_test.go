@@ -0,0 +1,207 @@
+package tcp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"practice/internal/storage"
+	"practice/internal/tui"
+	"practice/internal/user"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newRateLimiter(2, 3)
+	l.now = func() time.Time { return now }
+
+	steps := []struct {
+		after time.Duration
+		addr  string
+		want  bool
+	}{
+		{0, "a", true},
+		{0, "a", true},
+		{0, "a", true},
+		{0, "a", false}, // the burst is over
+		{0, "b", true},  // the addresses have their own buckets
+		{250 * time.Millisecond, "a", false},
+		{250 * time.Millisecond, "a", true}, // a token in 0.5s
+		{0, "a", false},
+		{10 * time.Second, "a", true}, // the bucket is full again, but not more
+		{0, "a", true},
+		{0, "a", true},
+		{0, "a", false},
+	}
+	for i, st := range steps {
+		now = now.Add(st.after)
+		if got := l.allow(st.addr); got != st.want {
+			t.Errorf("step %d: allow(%q) = %v, want %v", i, st.addr, got, st.want)
+		}
+	}
+}
+
+func TestRateLimiter_Prune(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newRateLimiter(1, 1)
+	l.now = func() time.Time { return now }
+	for i := 0; i < maxIdleBuckets; i++ {
+		l.allow(net.IPv4(10, 0, byte(i>>8), byte(i)).String())
+	}
+	now = now.Add(time.Second)
+	l.allow("new")
+	if n := len(l.buckets); n != 1 {
+		t.Errorf("got %d buckets, want 1", n)
+	}
+}
+
+// pipeSession serves the session over net.Pipe with the options and sends
+// the input. It returns the output and the error of the session.
+func pipeSession(t *testing.T, input string, opts ...Option) (string, error) {
+	t.Helper()
+	strg := storage.New(storage.NewMemory(user.User{ID: 1, Name: "John Doe"}))
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	s := &server{strg: strg, cfg: newConfig(opts), conns: make(map[net.Conn]struct{})}
+	if s.cfg.rate > 0 {
+		s.rate = newRateLimiter(s.cfg.rate, s.cfg.burst)
+	}
+
+	srv, cli := net.Pipe()
+	defer cli.Close()
+	ended := make(chan error, 1)
+	go func() {
+		ended <- s.handleConn(srv)
+		srv.Close()
+	}()
+	// The input is written while the output is read, as the pipe has no buffer.
+	go io.WriteString(cli, input)
+	out, _ := io.ReadAll(cli)
+
+	select {
+	case err := <-ended:
+		return string(out), err
+	case <-time.After(5 * time.Second):
+		t.Fatal("the session hasn't ended")
+		return "", nil
+	}
+}
+
+func TestServer_Limits(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		opts    []Option
+		wantErr error
+		want    []string // the substrings of the output
+		wantNot []string
+	}{
+		{
+			name:    "idle",
+			opts:    []Option{WithIdleTimeout(50 * time.Millisecond)},
+			wantErr: os.ErrDeadlineExceeded,
+			want:    []string{"memory > ", timeoutMessage},
+		},
+		{
+			name:    "idle after command",
+			input:   "show\n",
+			opts:    []Option{WithIdleTimeout(50 * time.Millisecond)},
+			wantErr: os.ErrDeadlineExceeded,
+			want:    []string{"John Doe", timeoutMessage},
+		},
+		{
+			name:    "command",
+			input:   "add\nAnn\n",
+			opts:    []Option{WithCommandTimeout(50 * time.Millisecond)},
+			wantErr: os.ErrDeadlineExceeded,
+			want:    []string{"Enter age: ", timeoutMessage},
+		},
+		{
+			name:    "no timeouts",
+			input:   "show\nquit\n",
+			opts:    []Option{WithIdleTimeout(0), WithCommandTimeout(0)},
+			wantErr: tui.ErrEndOfSession,
+			want:    []string{"John Doe"},
+		},
+		{
+			name:    "line length",
+			input:   "show " + strings.Repeat("x", 20) + "\nshow\n",
+			opts:    []Option{WithMaxLineLength(16)},
+			wantErr: tui.ErrLineTooLong,
+			want:    []string{"longer than 16 bytes"},
+			wantNot: []string{"John Doe"},
+		},
+		{
+			name:    "line length in prompt",
+			input:   "add\n" + strings.Repeat("x", 20) + "\n",
+			opts:    []Option{WithMaxLineLength(16)},
+			wantErr: tui.ErrLineTooLong,
+			want:    []string{"Enter name: "},
+		},
+		{
+			name:    "line length of JSON",
+			input:   "proto json\n{\"cmd\": \"show\", \"args\": {}}\n",
+			opts:    []Option{WithMaxLineLength(16)},
+			wantErr: tui.ErrLineTooLong,
+			want:    []string{`"code":"bad_request","message":"the request is longer than 16 bytes"`},
+		},
+		{
+			// Even quit is rejected, so the session ends by the idle timeout.
+			name:    "rate",
+			input:   "show\nshow\nshow\nquit\n",
+			opts:    []Option{WithRateLimit(0.001, 2), WithIdleTimeout(50 * time.Millisecond)},
+			wantErr: os.ErrDeadlineExceeded,
+			want:    []string{"John Doe", tui.ErrTooManyCommands.Error()},
+		},
+		{
+			name:    "rate of JSON",
+			input:   "proto json\n{\"cmd\": \"show\"}\n{\"cmd\": \"show\"}\n",
+			opts:    []Option{WithRateLimit(0.001, 2), WithIdleTimeout(50 * time.Millisecond)},
+			wantErr: os.ErrDeadlineExceeded,
+			want:    []string{`"John Doe"`, `"code":"rate_limited"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := pipeSession(t, tt.input, tt.opts...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("session error = %v, want %v", err, tt.wantErr)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(out, s) {
+					t.Errorf("output doesn't contain %q:\n%s", s, out)
+				}
+			}
+			for _, s := range tt.wantNot {
+				if strings.Contains(out, s) {
+					t.Errorf("output contains %q:\n%s", s, out)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_RateLimitPerIP(t *testing.T) {
+	strg := storage.New(storage.NewMemory())
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	addr := startServer(t, strg, WithRateLimit(0.001, 3))
+
+	// The sessions from the same address share the limit.
+	for i, want := range []int{0, 1} {
+		out, err := session(addr, "help\nhelp\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(out, tui.ErrTooManyCommands.Error()); n != want {
+			t.Errorf("session %d: got %d rejected commands, want %d:\n%s", i, n, want, out)
+		}
+	}
+}
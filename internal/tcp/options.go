@@ -0,0 +1,124 @@
+package tcp
+
+import (
+	"crypto/tls"
+	"practice/internal/auth"
+	"time"
+)
+
+const (
+	stdMaxConns       = 100
+	stdDrainTimeout   = 10 * time.Second
+	stdIdleTimeout    = 5 * time.Minute
+	stdCommandTimeout = time.Minute
+	stdMaxLineLength  = 64 << 10
+	stdUndoWindow     = 5 * time.Minute
+)
+
+// config holds the settings of the server and the client.
+type config struct {
+	maxConns       int
+	drainTimeout   time.Duration
+	idleTimeout    time.Duration
+	commandTimeout time.Duration
+	maxLineLength  int
+	rate           float64 // the commands per second from an IP address; 0 means no limit
+	burst          int
+	undoWindow     time.Duration
+	tls            *tls.Config
+	users          *auth.Store
+}
+
+// Option configures the server or the client.
+type Option func(*config)
+
+func newConfig(opts []Option) config {
+	cfg := config{
+		maxConns:       stdMaxConns,
+		drainTimeout:   stdDrainTimeout,
+		idleTimeout:    stdIdleTimeout,
+		commandTimeout: stdCommandTimeout,
+		maxLineLength:  stdMaxLineLength,
+		undoWindow:     stdUndoWindow,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithMaxConns limits the number of the simultaneous connections; the connections
+// over the limit are refused. By default, it is 100.
+func WithMaxConns(n int) Option {
+	return func(c *config) {
+		c.maxConns = n
+	}
+}
+
+// WithTLS makes the server or the client use TLS with the configuration
+// (see LoadServerTLS and LoadClientTLS). By default, the connections are plaintext.
+func WithTLS(cfg *tls.Config) Option {
+	return func(c *config) {
+		c.tls = cfg
+	}
+}
+
+// WithUsers makes the server ask for the name and the password at the start
+// of every session. The role of the user limits the commands of the session.
+// By default, the sessions have the write access without logging in.
+func WithUsers(users *auth.Store) Option {
+	return func(c *config) {
+		c.users = users
+	}
+}
+
+// WithDrainTimeout sets how long the server waits for the active sessions
+// to end on shutdown. By default, it is 10 seconds.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.drainTimeout = d
+	}
+}
+
+// WithIdleTimeout sets how long the server waits for the next command or the login
+// before it closes the session. Zero means forever. By default, it is 5 minutes.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.idleTimeout = d
+	}
+}
+
+// WithCommandTimeout sets how long a command, including its prompts, may take
+// before the server closes the session. Zero means forever. By default, it is 1 minute.
+func WithCommandTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.commandTimeout = d
+	}
+}
+
+// WithMaxLineLength limits the length of the input lines in bytes; the server
+// closes the session on a longer line. Zero means no limit. By default, it is 64 KiB.
+func WithMaxLineLength(n int) Option {
+	return func(c *config) {
+		c.maxLineLength = n
+	}
+}
+
+// WithRateLimit limits the commands from an IP address to rate per second
+// on average, and to burst at once. The commands over the limit are rejected.
+// By default, there is no limit.
+func WithRateLimit(rate float64, burst int) Option {
+	return func(c *config) {
+		c.rate, c.burst = rate, burst
+	}
+}
+
+// WithUndoWindow sets how long the server keeps the undo history of an ended
+// session, so the same user may undo the changes after reconnecting. Without
+// WithUsers, the sessions don't log in, and the history always ends with the
+// session. Zero means the history ends with the session. By default, it is 5 minutes.
+func WithUndoWindow(d time.Duration) Option {
+	return func(c *config) {
+		c.undoWindow = d
+	}
+}
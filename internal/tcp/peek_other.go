@@ -0,0 +1,11 @@
+//go:build !unix
+
+package tcp
+
+import "net"
+
+// peerDone reports false: the check is supported on unix only, so the closed
+// connection shows up on the next request.
+func peerDone(conn net.Conn) bool {
+	return false
+}
@@ -0,0 +1,30 @@
+//go:build unix
+
+package tcp
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerDone reports whether the peer has closed the connection or sent anything
+// on it, without blocking and without reading it.
+func peerDone(conn net.Conn) bool {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return true
+	}
+	var done bool
+	err = raw.Read(func(fd uintptr) bool {
+		var b [1]byte
+		n, _, err := syscall.Recvfrom(int(fd), b[:], syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		// n is 0 without an error at EOF.
+		done = err == nil || n > 0 || err != syscall.EAGAIN && err != syscall.EWOULDBLOCK
+		return true
+	})
+	return err != nil || done
+}
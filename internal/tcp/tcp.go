@@ -1,65 +1,251 @@
 package tcp
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
 	"practice/internal/storage"
 	"practice/internal/tui"
-	"practice/internal/user"
+	"sync"
+	"time"
 )
 
-func Server(c chan int, strg *storage.Storage, users *[]user.User) {
-	defer func() {
-		c <- 0
-	}()
+const (
+	// busyMessage is sent to the connections over the limit.
+	busyMessage = "The server is busy. Try again later."
+	// shutdownMessage is sent to the sessions closed by the shutdown.
+	shutdownMessage = "The server is shutting down. Bye."
+	// timeoutMessage is sent to the sessions closed by the idle or the command timeout.
+	timeoutMessage = "The session has timed out. Bye."
+)
+
+// ErrSessionsAborted is returned by Server if some sessions didn't end
+// in the drain timeout and were closed.
+var ErrSessionsAborted = errors.New("sessions are aborted on shutdown")
 
+// Server serves the text user interface on the address until ctx is canceled.
+// Every connection is served in its own goroutine. On cancellation, Server stops
+// accepting the connections and waits for the active sessions to end. The sessions
+// that last longer than the drain timeout are closed, and ErrSessionsAborted is returned.
+func Server(ctx context.Context, addr string, strg *storage.Storage, opts ...Option) error {
 	// Create listener.
-	listener, err := net.Listen("tcp", ":8000")
+	var lc net.ListenConfig
+	listener, err := lc.Listen(ctx, "tcp", addr)
 	if err != nil {
-		log.Fatal("tcp.Server: failed to create a listener: ", err)
+		return fmt.Errorf("failed to create a listener: %w", err)
+	}
+	return serve(ctx, listener, strg, newConfig(opts))
+}
+
+// Serve is like Server, but it serves the connections accepted by the listener.
+// It closes the listener.
+func Serve(ctx context.Context, listener net.Listener, strg *storage.Storage, opts ...Option) error {
+	return serve(ctx, listener, strg, newConfig(opts))
+}
+
+// server tracks the active sessions.
+type server struct {
+	strg *storage.Storage
+	cfg  config
+	rate *rateLimiter // nil if there is no limit
+	// histories keep the undo histories of the ended sessions; nil if they aren't kept.
+	histories *tui.Histories
+
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	sessions sync.WaitGroup
+}
+
+// serve serves the connections accepted by the listener until ctx is canceled
+// and drains the sessions. It closes the listener.
+func serve(ctx context.Context, listener net.Listener, strg *storage.Storage, cfg config) error {
+	if cfg.tls != nil {
+		listener = tls.NewListener(listener, cfg.tls)
+	}
+	s := &server{strg: strg, cfg: cfg, conns: make(map[net.Conn]struct{})}
+	if cfg.rate > 0 {
+		s.rate = newRateLimiter(cfg.rate, cfg.burst)
 	}
-	defer listener.Close()
+	if cfg.undoWindow > 0 {
+		s.histories = tui.NewHistories(cfg.undoWindow)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stopped:
+		}
+		listener.Close()
+	}()
+	s.accept(listener)
+	close(stopped)
+	return s.drain()
+}
+
+// accept accepts the connections until the listener is closed.
+func (s *server) accept(listener net.Listener) {
+	// The slots of the simultaneous connections.
+	slots := make(chan struct{}, s.cfg.maxConns)
 
 	// Listen for a new connection.
 	for {
 		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			log.Println("tcp.Server: failed to accept a connection:", err)
 			continue
 		}
 
-		err = handleConn(conn, strg, users)
-		if err != nil && err != tui.ErrEndOfSession {
-			log.Println("tcp.Server: handling connection:", err)
+		select {
+		case slots <- struct{}{}:
+		default:
+			go refuse(conn)
+			continue
 		}
-		conn.Close()
+		s.track(conn, true)
+		go func() {
+			defer func() {
+				s.track(conn, false)
+				conn.Close()
+				<-slots
+			}()
+			// The sessions aborted on shutdown fail with net.ErrClosed.
+			err := s.handleConn(conn)
+			switch {
+			case err == nil, err == tui.ErrEndOfSession, errors.Is(err, net.ErrClosed),
+				err == tui.ErrLineTooLong, errors.Is(err, os.ErrDeadlineExceeded):
+			case err == tui.ErrLoginFailed:
+				log.Println("tcp.Server: failed login from", conn.RemoteAddr())
+			default:
+				log.Println("tcp.Server: handling connection:", err)
+			}
+		}()
 	}
 }
 
-func handleConn(conn net.Conn, strg *storage.Storage, users *[]user.User) error {
-	return tui.Prompt(conn, conn, strg, users)
+// refuse tells the client over the limit that the server is busy and closes
+// the connection. With TLS, the write waits for the handshake, so it is done
+// off the accept loop and with a deadline.
+func refuse(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	fmt.Fprintln(conn, busyMessage)
 }
 
-func Client(c chan int) {
-	defer func() {
-		c <- 0
+// track adds the connection of a new session or removes the ended one.
+func (s *server) track(conn net.Conn, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		s.conns[conn] = struct{}{}
+		s.sessions.Add(1)
+	} else {
+		delete(s.conns, conn)
+		s.sessions.Done()
+	}
+}
+
+// drain waits for the active sessions to end. When the drain timeout passes,
+// it closes the rest of them.
+func (s *server) drain() error {
+	ended := make(chan struct{})
+	go func() {
+		s.sessions.Wait()
+		close(ended)
 	}()
 
-	conn, err := net.Dial("tcp", "localhost:8000")
+	timer := time.NewTimer(s.cfg.drainTimeout)
+	defer timer.Stop()
+	select {
+	case <-ended:
+		return nil
+	case <-timer.C:
+	}
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		// The session is blocked on reading, so the message doesn't interleave with its output.
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		fmt.Fprintln(conn, "\n"+shutdownMessage)
+		conn.Close()
+	}
+	s.mu.Unlock()
+	<-ended
+	return ErrSessionsAborted
+}
+
+// handleConn runs the session of the connection. If the server has the users,
+// the session starts with logging in.
+func (s *server) handleConn(conn net.Conn) error {
+	// With TLS, the handshake comes first, and the client that stays silent
+	// in it is timed out like an idle session.
+	conn.SetDeadline(deadline(s.cfg.idleTimeout))
+	if tc, ok := conn.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			return fmt.Errorf("TLS handshake: %w", err)
+		}
+	}
+	opts := []tui.Option{tui.WithLimits(s.limits(conn)), tui.WithMaxLineLength(s.cfg.maxLineLength)}
+	if s.histories != nil {
+		opts = append(opts, tui.WithHistories(s.histories))
+	}
+	err := tui.Serve(conn, conn, s.strg, s.cfg.users, opts...)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		fmt.Fprintln(conn, "\n"+timeoutMessage)
+	}
+	return err
+}
+
+// Client connects in and out to the server at the address until the server
+// ends the session or ctx is canceled. When in ends, the client waits for
+// the rest of the output of the server. Only the WithTLS option applies to the client.
+func Client(ctx context.Context, addr string, in io.Reader, out io.Writer, opts ...Option) error {
+	conn, err := dial(ctx, addr, newConfig(opts))
 	if err != nil {
-		log.Fatal("tcp.Client: failed connect to server: ", err)
+		return fmt.Errorf("failed to connect to server: %w", err)
 	}
 	defer conn.Close()
 
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
 	go func() {
-		if _, err = io.Copy(os.Stdout, conn); err != nil {
-			log.Println("tcp.Client: failed to redirect from connection to Stdout:", err)
+		// The copying is abandoned if in blocks after the session ends.
+		if _, err := io.Copy(conn, in); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Println("tcp.Client: failed to redirect the input to connection:", err)
+		}
+		if c, ok := conn.(interface{ CloseWrite() error }); ok {
+			c.CloseWrite()
 		}
 	}()
 
-	if _, err = io.Copy(conn, os.Stdin); err != nil {
-		log.Println("tcp.Client: failed to redirect from Stdin to connection:", err)
+	_, err = io.Copy(out, conn)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to redirect the connection to output: %w", err)
+	}
+	return nil
+}
+
+// dial connects to the server at the address, with TLS if it is configured.
+func dial(ctx context.Context, addr string, cfg config) (net.Conn, error) {
+	if cfg.tls == nil {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
 	}
+	d := tls.Dialer{Config: cfg.tls}
+	return d.DialContext(ctx, "tcp", addr)
 }
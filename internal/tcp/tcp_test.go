@@ -0,0 +1,898 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"practice/internal/auth"
+	"practice/internal/storage"
+	"practice/internal/user"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startServer serves the storage on a random port until the end of the test.
+func startServer(t *testing.T, strg *storage.Storage, opts ...Option) string {
+	t.Helper()
+	addr, _ := startServerContext(t, context.Background(), strg, opts...)
+	return addr
+}
+
+// startServerContext serves the storage on a random port until ctx is canceled
+// or the test ends. The returned function waits for the server to stop
+// and returns its error.
+func startServerContext(t *testing.T, ctx context.Context, strg *storage.Storage, opts ...Option) (string, func() error) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	var serveErr error
+	go func() {
+		serveErr = serve(ctx, listener, strg, newConfig(opts))
+		close(done)
+	}()
+	wait := func() error {
+		<-done
+		return serveErr
+	}
+	t.Cleanup(func() {
+		cancel()
+		wait()
+	})
+	return listener.Addr().String(), wait
+}
+
+// session sends the input to the server, ends it and returns the output.
+func session(addr, input string) (string, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if _, err = io.WriteString(conn, input); err != nil {
+		return "", err
+	}
+	if err = conn.(*net.TCPConn).CloseWrite(); err != nil {
+		return "", err
+	}
+	out, err := io.ReadAll(conn)
+	return string(out), err
+}
+
+func TestServer_Concurrent(t *testing.T) {
+	const clients = 50
+	strg := storage.New(storage.NewMemory())
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	addr := startServer(t, strg, WithMaxConns(3*clients))
+
+	// Every client adds a user, and the rest of them show and remove the users meanwhile.
+	var wg sync.WaitGroup
+	errs := make(chan error, 3*clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(3)
+		go func(i int) {
+			defer wg.Done()
+			in := fmt.Sprintf("add\nUser %02d\n%d\ny\n70\nBook %d\n\n", i, 20+i, i%3)
+			if _, err := session(addr, in); err != nil {
+				errs <- err
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			out, err := session(addr, "show\nquit\n")
+			if err == nil && !strings.Contains(out, "Number of active users") {
+				err = fmt.Errorf("show output: %q", out)
+			}
+			if err != nil {
+				errs <- err
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			if _, err := session(addr, fmt.Sprintf("remove\nNobody %d\n", i)); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	users := strg.Users()
+	if len(users) != clients {
+		t.Fatalf("got %d users, want %d", len(users), clients)
+	}
+	var names []string
+	ids := make(map[uint64]bool)
+	for _, u := range users {
+		names = append(names, u.Name)
+		ids[u.ID] = true
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if want := fmt.Sprintf("User %02d", i); name != want {
+			t.Errorf("names[%d] = %q, want %q", i, name, want)
+		}
+	}
+	if len(ids) != clients {
+		t.Errorf("got %d distinct IDs, want %d", len(ids), clients)
+	}
+}
+
+func TestServer_ConcurrentRemove(t *testing.T) {
+	const clients = 20
+	strg := storage.New(storage.NewMemory(user.User{ID: 1, Name: "John Doe"}))
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	addr := startServer(t, strg, WithMaxConns(clients))
+
+	// Only one of the clients removes the user.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	deleted := 0
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := session(addr, "remove\nJohn Doe\n")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			deleted += strings.Count(out, "User deleted")
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if deleted != 1 {
+		t.Errorf("the user is deleted %d times, want 1", deleted)
+	}
+	if n := len(strg.Users()); n != 0 {
+		t.Errorf("got %d users, want 0", n)
+	}
+}
+
+func TestServer_MaxConns(t *testing.T) {
+	strg := storage.New(storage.NewMemory())
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	addr := startServer(t, strg, WithMaxConns(2))
+
+	// Two connections take the slots.
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, greeting, err := dialGreeting(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if greeting == busyMessage+"\n" {
+			t.Fatalf("connection %d is refused", i)
+		}
+		conns = append(conns, conn)
+	}
+
+	// The third one is refused.
+	conn, greeting, err := dialGreeting(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if greeting != busyMessage+"\n" {
+		t.Errorf("greeting = %q, want %q", greeting, busyMessage+"\n")
+	}
+
+	// The slot is freed when the session ends.
+	conns[0].Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, greeting, err = dialGreeting(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		if greeting != busyMessage+"\n" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the slot isn't freed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// dialGreeting connects to the server and reads the first line it sends.
+func dialGreeting(addr string) (net.Conn, string, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, "", err
+	}
+	greeting, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+	return conn, greeting, nil
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	strg := storage.New(storage.NewMemory())
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	addr, wait := startServerContext(t, ctx, strg)
+
+	conn, _, err := dialGreeting(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cancel()
+
+	// The new connections aren't accepted.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			break
+		}
+		c.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("the server accepts connections after the shutdown")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The active session goes on until it ends.
+	if _, err = io.WriteString(conn, "add\nJohn Doe\n30\ny\n80\n\n"); err != nil {
+		t.Fatal(err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+	io.ReadAll(conn)
+	if err = wait(); err != nil {
+		t.Errorf("serve() error = %v, want nil", err)
+	}
+	if n := len(strg.Users()); n != 1 {
+		t.Errorf("got %d users, want 1", n)
+	}
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	strg := storage.New(storage.NewMemory())
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	addr, wait := startServerContext(t, ctx, strg, WithDrainTimeout(50*time.Millisecond))
+
+	conn, _, err := dialGreeting(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cancel()
+
+	// The idle session is closed with the message.
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	out, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), shutdownMessage) {
+		t.Errorf("output = %q, want the shutdown message", out)
+	}
+	if err = wait(); !errors.Is(err, ErrSessionsAborted) {
+		t.Errorf("serve() error = %v, want %v", err, ErrSessionsAborted)
+	}
+}
+
+func TestClient(t *testing.T) {
+	strg := storage.New(storage.NewMemory(user.User{ID: 1, Name: "John Doe", Active: true}))
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	addr := startServer(t, strg)
+
+	var out bytes.Buffer
+	if err := Client(context.Background(), addr, strings.NewReader("show\nquit\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "John Doe") || !strings.Contains(out.String(), "Number of active users: 1") {
+		t.Errorf("output = %q, want the table of the users", out.String())
+	}
+
+	// The client waits for the input until it is canceled.
+	in, w := io.Pipe()
+	defer w.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- Client(ctx, addr, in, io.Discard)
+	}()
+	cancel()
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Client() error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client() isn't canceled")
+	}
+}
+
+// selfSigned returns the TLS configurations of the server with a new self-signed
+// certificate for 127.0.0.1 and of the client that trusts it.
+func selfSigned(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Go through the files like the application does.
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err = os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if server, err = LoadServerTLS(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if client, err = LoadClientTLS(certFile); err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+func TestServer_TLS(t *testing.T) {
+	strg := storage.New(storage.NewMemory(user.User{ID: 1, Name: "John Doe"}))
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	serverTLS, clientTLS := selfSigned(t)
+	addr := startServer(t, strg, WithTLS(serverTLS))
+
+	var out bytes.Buffer
+	err := Client(context.Background(), addr, strings.NewReader("show\nquit\n"), &out, WithTLS(clientTLS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "John Doe") {
+		t.Errorf("output = %q, want the table of the users", out.String())
+	}
+
+	// The client that doesn't trust the certificate fails.
+	untrusted, err := LoadClientTLS("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Client(context.Background(), addr, strings.NewReader("quit\n"), io.Discard, WithTLS(untrusted))
+	if err == nil {
+		t.Error("Client() with untrusted certificate error = nil")
+	}
+	// The plaintext client gets nothing.
+	out.Reset()
+	Client(context.Background(), addr, strings.NewReader("show\nquit\n"), &out)
+	if strings.Contains(out.String(), "John Doe") {
+		t.Errorf("plaintext output = %q", out.String())
+	}
+}
+
+func TestServer_SilentTLSClient(t *testing.T) {
+	strg := storage.New(storage.NewMemory())
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	serverTLS, clientTLS := selfSigned(t)
+	addr := startServer(t, strg, WithTLS(serverTLS), WithMaxConns(1), WithIdleTimeout(2*time.Second))
+
+	// The silent clients don't send the TLS handshake. The first one takes the slot.
+	var silent []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		silent = append(silent, conn)
+	}
+
+	// The refusal of the second one doesn't hold up the next client.
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", addr, clientTLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	greeting, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if greeting != busyMessage+"\n" {
+		t.Errorf("greeting = %q, want %q", greeting, busyMessage+"\n")
+	}
+
+	// The session of the first one times out.
+	silent[0].SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(silent[0]); err != nil {
+		t.Errorf("the silent connection isn't closed: %v", err)
+	}
+}
+
+func TestServer_Login(t *testing.T) {
+	users := auth.NewStore()
+	users.Set("admin", "secret", auth.RoleWrite)
+	users.Set("guest", "guest", auth.RoleRead)
+
+	tests := []struct {
+		name    string
+		input   string
+		want    []string // the substrings of the output
+		wantNot []string
+		users   int // the number of the users left
+	}{
+		{
+			name:  "writer",
+			input: "admin\nsecret\nremove\nJohn Doe\nquit\n",
+			want:  []string{"Your access: write", "User deleted"},
+			users: 0,
+		},
+		{
+			name:    "reader",
+			input:   "guest\nguest\nhelp\nshow\nremove\nJohn Doe\nquit\n",
+			want:    []string{"Your access: read", "John Doe", "Permission denied"},
+			wantNot: []string{"User deleted", "remove  "},
+			users:   1,
+		},
+		{
+			name:  "retry",
+			input: "admin\nguest\nadmin\nsecret\nquit\n",
+			want:  []string{auth.ErrBadCredentials.Error(), "Your access: write"},
+			users: 1,
+		},
+		{
+			name:    "failed",
+			input:   "admin\n1\nadmin\n2\nadmin\n3\n",
+			want:    []string{"Too many failed attempts"},
+			wantNot: []string{"Your access"},
+			users:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strg := storage.New(storage.NewMemory(user.User{ID: 1, Name: "John Doe"}))
+			if err := strg.Load(); err != nil {
+				t.Fatal(err)
+			}
+			defer strg.Close()
+			addr := startServer(t, strg, WithUsers(users))
+
+			out, err := session(addr, tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(out, s) {
+					t.Errorf("output doesn't contain %q:\n%s", s, out)
+				}
+			}
+			for _, s := range tt.wantNot {
+				if strings.Contains(out, s) {
+					t.Errorf("output contains %q:\n%s", s, out)
+				}
+			}
+			if n := len(strg.Users()); n != tt.users {
+				t.Errorf("got %d users, want %d", n, tt.users)
+			}
+		})
+	}
+}
+
+func TestServer_Edit(t *testing.T) {
+	ann := user.User{ID: 1, Name: "Ann", Age: 30, Active: true, Mass: 60, Books: []string{"Dune", "Emma"}}
+	bob := user.User{ID: 2, Name: "Bob", Age: 40}
+
+	tests := []struct {
+		name  string
+		input string
+		want  []user.User
+		out   string // a substring of the output
+	}{
+		{
+			name:  "defaults",
+			input: "edit Ann\n\n\n\n\n\n",
+			want:  []user.User{ann, bob},
+			out:   "Enter age [30]: ",
+		},
+		{
+			name:  "fields",
+			input: "edit Ann\nAnna\nold\n31\nno\n0.7\n+Ulysses\n+Dune\n-Emma\n-Nope\n\n",
+			want: []user.User{
+				{ID: 1, Name: "Anna", Age: 31, Mass: 70, Books: []string{"Dune", "Ulysses"}},
+				bob,
+			},
+			out: `No such book: "Nope"`,
+		},
+		{
+			name:  "no books",
+			input: "edit\nAnn\n\n\n\n\n-Dune\n-Emma\n\n",
+			want:  []user.User{{ID: 1, Name: "Ann", Age: 30, Active: true, Mass: 60}, bob},
+			out:   "Books: none",
+		},
+		{
+			name:  "not found",
+			input: "edit Nobody\n",
+			want:  []user.User{ann, bob},
+			out:   "user is not found",
+		},
+		{
+			name:  "JSON",
+			input: "proto json\n" + `{"cmd": "edit", "args": {"name": "Bob", "new_name": "Robert", "mass": 80, "add_books": ["Dune"]}}` + "\n",
+			want:  []user.User{ann, {ID: 2, Name: "Robert", Age: 40, Mass: 80, Books: []string{"Dune"}}},
+			out:   `"name":"Robert"`,
+		},
+		{
+			name:  "JSON name taken",
+			input: "proto json\n" + `{"cmd": "edit", "args": {"name": "Bob", "new_name": "Ann", "age": 41}}` + "\n",
+			want:  []user.User{ann, bob},
+			out:   `"code":"conflict"`,
+		},
+		{
+			name:  "name taken",
+			input: "edit Bob\nAnn\n\n\n\n\n\n",
+			want:  []user.User{ann, bob},
+			out:   `user already exists: "Ann"`,
+		},
+		{
+			name:  "JSON invalid",
+			input: "proto json\n" + `{"cmd": "edit", "args": {"name": "Bob", "remove_books": ["Dune"]}}` + "\n",
+			want:  []user.User{ann, bob},
+			out:   `"code":"bad_request"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strg := storage.New(storage.NewMemory(ann, bob))
+			if err := strg.Load(); err != nil {
+				t.Fatal(err)
+			}
+			defer strg.Close()
+			addr := startServer(t, strg)
+
+			out, err := session(addr, tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out, tt.out) {
+				t.Errorf("output doesn't contain %q:\n%s", tt.out, out)
+			}
+			// The position of the user is kept.
+			if got := strg.Users(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("users = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_Find(t *testing.T) {
+	strg := storage.New(storage.NewMemory(
+		user.User{ID: 1, Name: "Ann", Age: 30, Active: true, Books: []string{"Harry Potter"}},
+		user.User{ID: 2, Name: "Bob", Age: 40, Active: true},
+		user.User{ID: 3, Name: "Carl", Age: 50, Books: []string{"Harry Potter", "Dune"}},
+	))
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	addr := startServer(t, strg)
+
+	tests := []struct {
+		name    string
+		input   string
+		want    []string // the substrings of the output
+		wantNot []string
+	}{
+		{
+			name:    "find",
+			input:   "find age>30 book~\"potter\"\n",
+			want:    []string{"Carl"},
+			wantNot: []string{"Ann", "Bob"},
+		},
+		{
+			name:    "find by name",
+			input:   "find bo\n",
+			want:    []string{"Bob"},
+			wantNot: []string{"Ann", "Carl"},
+		},
+		{
+			name:  "find without query",
+			input: "find\n",
+			want:  []string{"Usage: find <query>"},
+		},
+		{
+			name:  "find invalid",
+			input: "find age>old\n",
+			want:  []string{`invalid query: "age>old"`},
+		},
+		{
+			name:    "filter",
+			input:   "filter active=yes\nshow\n",
+			want:    []string{"Filter: active=yes", "Ann", "Bob"},
+			wantNot: []string{"Carl"},
+		},
+		{
+			name:  "filter cleared",
+			input: "filter active=yes\nfilter\nshow\n",
+			want:  []string{"Filter cleared", "Carl"},
+		},
+		{
+			name:  "JSON",
+			input: "proto json\n" + `{"cmd": "find", "args": {"query": "age<=30"}}` + "\n",
+			want:  []string{`"data":[{"id":1,"name":"Ann"`},
+		},
+		{
+			name:  "JSON invalid",
+			input: "proto json\n" + `{"cmd": "filter", "args": {"query": "age~3"}}` + "\n",
+			want:  []string{`"code":"bad_request"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := session(addr, tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(out, s) {
+					t.Errorf("output doesn't contain %q:\n%s", s, out)
+				}
+			}
+			for _, s := range tt.wantNot {
+				if strings.Contains(out, s) {
+					t.Errorf("output contains %q:\n%s", s, out)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_Show(t *testing.T) {
+	strg := storage.New(storage.NewMemory(
+		user.User{ID: 1, Name: "Ann", Age: 30, Active: true, Mass: 70},
+		user.User{ID: 2, Name: "Bob", Age: 40, Mass: 60},
+		user.User{ID: 3, Name: "Carl", Age: 20, Active: true, Mass: 80},
+	))
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	addr := startServer(t, strg)
+
+	tests := []struct {
+		name  string
+		input string
+		want  []string // the substrings of the output in the order
+	}{
+		{"sort", "show sort=age desc\n", []string{"Bob", "Ann", "Carl"}},
+		{"sort quoted", "show sort=\"active desc, mass desc\"\n", []string{"Carl", "Ann", "Bob"}},
+		{"page", "show sort=-mass offset=1 limit=1\n", []string{"Ann", "Number of active users: 1"}},
+		{"filter", "filter active=yes\nshow sort=age limit=5\n", []string{"Carl", "Ann", "Number of active users: 2"}},
+		{"invalid sort", "show sort=height\n", []string{`invalid order: unknown field "height"`}},
+		{"invalid limit", "show limit=-1\n", []string{`limit must be a non-negative number: "-1"`}},
+		{"unknown option", "show age\n", []string{`Expected an option like sort=<value>, got "age"`}},
+		{
+			"JSON",
+			"proto json\n" + `{"cmd": "show", "args": {"sort": "name desc", "limit": 2}}` + "\n",
+			[]string{`"name":"Carl"`, `"name":"Bob","age":40,"active":false,"mass":60,"books":null}],"error":null`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := session(addr, tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rest := out
+			for _, s := range tt.want {
+				i := strings.Index(rest, s)
+				if i < 0 {
+					t.Fatalf("output doesn't contain %q after the previous substrings:\n%s", s, out)
+				}
+				rest = rest[i+len(s):]
+			}
+		})
+	}
+}
+
+func TestServer_SingleLine(t *testing.T) {
+	strg := storage.New(storage.NewMemory(user.User{ID: 1, Name: "Ann"}))
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	addr := startServer(t, strg)
+
+	out, err := session(addr, `add name="Jane Doe" age=31 active=yes mass=62.5 books="Dune;Emma"`+"\n"+
+		"edit Jane Doe remove_books=Dune\nremove Ann\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, ": ") {
+		t.Errorf("the commands prompted for the arguments (\"Enter ...: \"):\n%s", out)
+	}
+	want := []user.User{{ID: 2, Name: "Jane Doe", Age: 31, Active: true, Mass: 62.5, Books: []string{"Emma"}}}
+	if got := strg.Users(); !reflect.DeepEqual(got, want) {
+		t.Errorf("users = %+v, want %+v", got, want)
+	}
+}
+
+func TestServer_Undo(t *testing.T) {
+	ann := user.User{ID: 1, Name: "Ann", Age: 30}
+	users := auth.NewStore()
+	users.Set("admin", "secret", auth.RoleWrite)
+	users.Set("eve", "secret", auth.RoleWrite)
+
+	strg := storage.New(storage.NewMemory(ann))
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	addr := startServer(t, strg, WithUsers(users))
+
+	// The sessions one after another.
+	steps := []struct {
+		input string
+		out   string // a substring of the output
+	}{
+		{"admin\nsecret\nremove Ann\n", "User deleted"},
+		{"admin\nsecret\nundo\n", "Undone: remove Ann"},
+		{"eve\nsecret\nundo\n", "Nothing to undo"},
+		{"admin\nsecret\nredo\nundo\nedit Ann age=40\n", "Redone: remove Ann\nmemory > Undone: remove Ann"},
+		{"eve\nsecret\nedit Ann age=50\n", "User updated"},
+		{"admin\nsecret\nundo\nundo\n", "Can't undo edit Ann: another session has changed"},
+		{"admin\nsecret\nundo\n", "Nothing to undo"},
+	}
+	for i, step := range steps {
+		out, err := session(addr, step.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, step.out) {
+			t.Errorf("session %d: output doesn't contain %q:\n%s", i+1, step.out, out)
+		}
+	}
+	want := []user.User{{ID: 1, Name: "Ann", Age: 50}}
+	if got := strg.Users(); !reflect.DeepEqual(got, want) {
+		t.Errorf("users = %+v, want %+v", got, want)
+	}
+}
+
+func TestServer_UndoWindow(t *testing.T) {
+	tests := []struct {
+		name   string
+		window time.Duration
+		wait   time.Duration
+		out    string // the output of undo after reconnecting
+	}{
+		{"within", time.Minute, 0, "Undone: remove Ann"},
+		{"expired", 50 * time.Millisecond, 100 * time.Millisecond, "Nothing to undo"},
+		{"not kept", 0, 0, "Nothing to undo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strg := storage.New(storage.NewMemory(user.User{ID: 1, Name: "Ann"}))
+			if err := strg.Load(); err != nil {
+				t.Fatal(err)
+			}
+			defer strg.Close()
+			users := auth.NewStore()
+			users.Set("admin", "secret", auth.RoleWrite)
+			addr := startServer(t, strg, WithUsers(users), WithUndoWindow(tt.window))
+
+			if _, err := session(addr, "admin\nsecret\nremove Ann\n"); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(tt.wait)
+			out, err := session(addr, "admin\nsecret\nundo\n")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out, tt.out) {
+				t.Errorf("output doesn't contain %q:\n%s", tt.out, out)
+			}
+		})
+	}
+}
+
+func TestServer_UndoAnonymous(t *testing.T) {
+	strg := storage.New(storage.NewMemory(user.User{ID: 1, Name: "Ann"}, user.User{ID: 2, Name: "Bob"}))
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	addr := startServer(t, strg, WithUndoWindow(time.Minute))
+
+	// Both connections come from the same host, but the sessions don't log in,
+	// so neither of them may undo the changes of the other.
+	steps := []struct {
+		input string
+		out   string // a substring of the output
+	}{
+		{"remove Ann\nremove Bob\nundo\n", "Undone: remove Bob"},
+		{"undo\n", "Nothing to undo"},
+	}
+	for i, step := range steps {
+		out, err := session(addr, step.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, step.out) {
+			t.Errorf("session %d: output doesn't contain %q:\n%s", i+1, step.out, out)
+		}
+	}
+	want := []user.User{{ID: 2, Name: "Bob"}}
+	if got := strg.Users(); !reflect.DeepEqual(got, want) {
+		t.Errorf("users = %+v, want %+v", got, want)
+	}
+}
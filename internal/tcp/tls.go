@@ -0,0 +1,37 @@
+package tcp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+)
+
+// LoadServerTLS returns the TLS configuration of the server with the certificate
+// and the private key read from the PEM files.
+func LoadServerTLS(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// LoadClientTLS returns the TLS configuration of the client. The server certificate
+// is verified with the CA certificates read from the PEM file, e.g. the self-signed
+// certificate of the server. If caFile is empty, the system CA certificates are used.
+func LoadClientTLS(caFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.RootCAs = x509.NewCertPool()
+	if !cfg.RootCAs.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificates in " + caFile)
+	}
+	return cfg, nil
+}
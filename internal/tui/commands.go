@@ -0,0 +1,497 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"practice/internal/auth"
+	"practice/internal/storage"
+	"practice/internal/user"
+	"strings"
+	"unicode"
+
+	"golang.org/x/exp/slices"
+)
+
+// values are the named arguments of a command. A name may have several values,
+// like the books of a user.
+type values map[string][]string
+
+// get returns the last value of the name, or "" if there is none.
+func (v values) get(name string) string {
+	if vs := v[name]; len(vs) > 0 {
+		return vs[len(vs)-1]
+	}
+	return ""
+}
+
+// set replaces the values of the name.
+func (v values) set(name string, vs ...string) {
+	v[name] = vs
+}
+
+// command is a command of the session. The same command serves the prompt and
+// the JSON protocol: exec does the work, and the prompt asks for the arguments
+// and prints the result.
+type command struct {
+	name   string
+	args   string   // the usage of the arguments
+	params []string // the names of the positional arguments in the prompt
+	// options are the names of the arguments given as name=value in the prompt
+	// after the positional ones.
+	options []string
+	help    string
+	write   bool // the command changes the database, so it needs auth.RoleWrite
+	local   bool // the command reads the local files, so it isn't served to the clients
+	// ask prompts for the arguments that aren't given in the prompt.
+	ask func(s *session, args values) error
+	// exec runs the command and returns the data of the response.
+	exec func(s *session, args values) (any, error)
+	// print prints the data returned by exec in the prompt.
+	print func(s *session, data any)
+}
+
+// The fields of the users given as options in the prompt.
+var (
+	addFields  = []string{"name", "age", "active", "mass", "books"}
+	editFields = []string{"new_name", "age", "active", "mass", "books", "add_books", "remove_books"}
+)
+
+// commands are the commands of the session in the order of the help.
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "add", args: "[<fields>]", options: addFields, write: true,
+			help: "Adds user to the database; the fields are like name=\"Jane Doe\" age=31 active=yes mass=62.5 books=\"Dune;Emma\"",
+			ask:  (*session).askUser, exec: (*session).add, print: printText("User added")},
+		{name: "backups", help: "Lists the backups of the database",
+			exec: (*session).backups, print: (*session).printBackups},
+		{name: "begin", help: "Begins a transaction: the changes are seen by the session only until commit", write: true,
+			exec: (*session).begin, print: (*session).printBegin},
+		{name: "books", help: "Prints the average age of the readers per book",
+			exec: (*session).books, print: (*session).printBooks},
+		{name: "commit", help: "Applies the changes of the transaction at once", write: true,
+			exec: (*session).commit, print: (*session).printCommit},
+		{name: "edit", args: "<name> [<fields>]", params: []string{"name"}, options: editFields, write: true,
+			help: "Changes the user; the fields are like for add, and new_name, add_books and remove_books",
+			ask:  (*session).askEdit, exec: (*session).edit, print: printText("User updated")},
+		{name: "filter", args: "[<query>]", params: []string{"query"}, help: "Makes show print only the users matching the query",
+			exec: (*session).setFilter, print: (*session).printFilter},
+		{name: "find", args: "<query>", params: []string{"query"}, help: "Prints the users matching the query, like age>30 book~\"Potter\"",
+			exec: (*session).find, print: (*session).printUsers},
+		{name: "get", args: "<name>", params: []string{"name"}, help: "Prints the user with the name",
+			exec: (*session).get, print: (*session).printUsers},
+		{name: "help", help: "Show help",
+			exec: (*session).help, print: (*session).printHelp},
+		{name: "import", args: "<file> [<options>]", params: []string{"file"}, options: []string{"format", "dry_run"},
+			write: true, local: true,
+			help: "Imports the users from the CSV or JSON file; the options are format=csv|json and dry_run=yes",
+			ask:  (*session).askImport, exec: (*session).importUsers, print: (*session).printImport},
+		{name: "proto", args: "json", params: []string{"mode"}, help: "Switches to the JSON line protocol",
+			exec: (*session).proto},
+		{name: "quit", help: "Exit this program",
+			exec: (*session).quit},
+		{name: "redo", help: "Makes the last undone change again", write: true,
+			exec: (*session).redo, print: (*session).printRedo},
+		{name: "remove", args: "[<name>]", params: []string{"name"}, help: "Removes the user from the database", write: true,
+			ask: (*session).askName, exec: (*session).remove, print: printText("User deleted")},
+		{name: "restore", args: "<id>", params: []string{"id"}, help: "Replaces the users with the backup", write: true,
+			exec: (*session).restore, print: printText("Backup restored")},
+		{name: "rollback", help: "Discards the changes of the transaction", write: true,
+			exec: (*session).rollback, print: (*session).printRollback},
+		{name: "show", args: "[<options>]", options: []string{"sort", "limit", "offset"},
+			help: "Prints the contents of the table; the options are like sort=\"age desc, name\" limit=10 offset=20",
+			exec: (*session).show, print: (*session).printUsers},
+		{name: "undo", help: "Undoes the last add, edit or remove of the session", write: true,
+			exec: (*session).undo, print: (*session).printUndo},
+	}
+}
+
+// lookup returns the command with the name.
+func lookup(name string) (command, bool) {
+	for _, cmd := range commands {
+		if strings.EqualFold(cmd.name, name) {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// complete returns the completions of the end of the line for the editor:
+// the names of the commands, of the users and of the options. The candidates
+// replace the line from start.
+func (s *session) complete(line string) (start int, candidates []string) {
+	name, rest := cutField(line)
+	if rest == "" {
+		start = len(line) - len(name)
+		for _, cmd := range commands {
+			if hasPrefixFold(cmd.name, name) {
+				candidates = append(candidates, cmd.name)
+			}
+		}
+		return start, candidates
+	}
+	cmd, ok := lookup(name)
+	if !ok {
+		return 0, nil
+	}
+
+	if _, options := cutOptions(rest, cmd.options); slices.Contains(cmd.params, "name") && options == "" {
+		prefix := strings.TrimLeftFunc(rest, unicode.IsSpace)
+		for _, u := range s.db().Users() {
+			if hasPrefixFold(u.Name, prefix) {
+				candidates = append(candidates, u.Name)
+			}
+		}
+		if len(candidates) > 0 {
+			slices.Sort(candidates)
+			return len(line) - len(prefix), slices.Compact(candidates)
+		}
+	}
+	start = strings.LastIndexFunc(line, unicode.IsSpace) + 1
+	if word := line[start:]; !strings.Contains(word, "=") {
+		for _, option := range cmd.options {
+			if hasPrefixFold(option, word) {
+				candidates = append(candidates, option+"=")
+			}
+		}
+	}
+	return start, candidates
+}
+
+// hasPrefixFold is like strings.HasPrefix, but ignores the case.
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// errProtoJSON is returned by the proto command to switch the session to the JSON protocol.
+var errProtoJSON = errors.New("switch to JSON protocol")
+
+// The codes of the errors of the JSON protocol.
+const (
+	CodeBadRequest     = "bad_request"
+	CodeUnknownCommand = "unknown_command"
+	CodeUnauthorized   = "unauthorized"
+	CodeForbidden      = "forbidden"
+	CodeNotFound       = "not_found"
+	CodeConflict       = "conflict"
+	CodeReadOnly       = "read_only"
+	CodeRateLimited    = "rate_limited"
+	CodeInternal       = "internal"
+)
+
+// Error is the error of a command. In the JSON protocol, it is the error of the response.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// badArgs returns the error of the invalid arguments.
+func badArgs(format string, a ...any) *Error {
+	return &Error{Code: CodeBadRequest, Message: fmt.Sprintf(format, a...)}
+}
+
+// toError converts the error of a command to *Error.
+func toError(err error) *Error {
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, ErrUserNotFound),
+		errors.Is(err, storage.ErrBackupNotFound),
+		errors.Is(err, storage.ErrNoBackups):
+		return &Error{Code: CodeNotFound, Message: err.Error()}
+	case errors.Is(err, storage.ErrReadOnly):
+		return &Error{Code: CodeReadOnly, Message: err.Error()}
+	case errors.Is(err, storage.ErrNameTaken):
+		return &Error{Code: CodeConflict, Message: err.Error()}
+	case errors.Is(err, user.ErrInvalidQuery), errors.Is(err, user.ErrInvalidOrder),
+		errors.Is(err, user.ErrInvalidPage), errors.Is(err, user.ErrUnterminatedQuote),
+		errors.Is(err, user.ErrInvalidQuoted):
+		return &Error{Code: CodeBadRequest, Message: err.Error()}
+	case errors.Is(err, ErrTooManyCommands):
+		return &Error{Code: CodeRateLimited, Message: err.Error()}
+	}
+	return &Error{Code: CodeInternal, Message: err.Error()}
+}
+
+// helpEntry describes a command in the help.
+type helpEntry struct {
+	Command string `json:"command"`
+	Args    string `json:"args,omitempty"`
+	Help    string `json:"help"`
+	Write   bool   `json:"write"`
+}
+
+// help returns the commands available to the role of the session.
+func (s *session) help(args values) (any, error) {
+	entries := []helpEntry{}
+	for _, cmd := range commands {
+		if cmd.write && s.role != auth.RoleWrite || cmd.local && s.remote {
+			continue
+		}
+		entries = append(entries, helpEntry{Command: cmd.name, Args: cmd.args, Help: cmd.help, Write: cmd.write})
+	}
+	return entries, nil
+}
+
+func (s *session) quit(args values) (any, error) {
+	return nil, ErrEndOfSession
+}
+
+func (s *session) proto(args values) (any, error) {
+	mode := args.get("mode")
+	if !strings.EqualFold(mode, "json") {
+		return nil, badArgs("Unknown protocol %q. Usage: proto json", mode)
+	}
+	return nil, errProtoJSON
+}
+
+// add adds the user given by the arguments "name", "age", "active", "mass" and "books",
+// and returns them.
+func (s *session) add(args values) (any, error) {
+	u, err := parseUser(args)
+	if err != nil {
+		return nil, err
+	}
+	if u, err = s.db().Add(u); err != nil {
+		return nil, err
+	}
+	s.record(change{cmd: "add", name: u.Name, swaps: []storage.Swap{{New: &u}}})
+	return u, nil
+}
+
+// parseUser returns the user given by the arguments. Only the name is required.
+func parseUser(args values) (u user.User, err error) {
+	if u.Name = strings.TrimSpace(args.get("name")); u.Name == "" {
+		return u, badArgs("no name is entered")
+	}
+	return u, setFields(&u, args)
+}
+
+// setFields sets the fields of the user given by the arguments "age", "active",
+// "mass" and "books". The other fields are left as they are.
+func setFields(u *user.User, args values) error {
+	var err error
+	if _, ok := args["age"]; ok {
+		if u.Age, err = user.ParseAge(args.get("age")); err != nil {
+			return badArgs("%v", err)
+		}
+	}
+	if _, ok := args["active"]; ok {
+		if u.Active, err = user.ParseActive(args.get("active")); err != nil {
+			return badArgs("%v", err)
+		}
+	}
+	if _, ok := args["mass"]; ok {
+		u.Mass = 0
+		if in := args.get("mass"); strings.TrimSpace(in) != "" {
+			if u.Mass, err = user.ParseMass(in); err != nil {
+				return badArgs("%v", err)
+			}
+		}
+	}
+	if books, ok := args["books"]; ok {
+		u.Books = nil
+		for _, book := range books {
+			if book = strings.TrimSpace(book); book != "" {
+				u.Books = append(u.Books, book)
+			}
+		}
+	}
+	return nil
+}
+
+// edit changes the user with the name given by the argument "name" and returns them.
+// The argument "new_name" renames the user, and "age", "active", "mass" and "books"
+// replace the fields like for add. The arguments "add_books" and "remove_books"
+// change the books one by one.
+func (s *session) edit(args values) (any, error) {
+	name := strings.TrimSpace(args.get("name"))
+	if name == "" {
+		return nil, badArgs("Usage: edit <name>")
+	}
+	users := s.db().Users()
+	i, ok := user.Slice(users).FindName(name)
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	before := users[i]
+	u := before
+	// The books are shared with the storage, so they are changed in a copy.
+	u.Books = slices.Clone(u.Books)
+
+	if _, ok := args["new_name"]; ok {
+		if u.Name = strings.TrimSpace(args.get("new_name")); u.Name == "" {
+			return nil, badArgs("no name is entered")
+		}
+	}
+	if err := setFields(&u, args); err != nil {
+		return nil, err
+	}
+	for _, book := range args["remove_books"] {
+		j := slices.Index(u.Books, strings.TrimSpace(book))
+		if j < 0 {
+			return nil, badArgs("the user hasn't read %q", book)
+		}
+		u.Books = slices.Delete(u.Books, j, j+1)
+	}
+	for _, book := range args["add_books"] {
+		if book = strings.TrimSpace(book); book != "" && !slices.Contains(u.Books, book) {
+			u.Books = append(u.Books, book)
+		}
+	}
+	if len(u.Books) == 0 {
+		u.Books = nil // as decoded from the database
+	}
+
+	// Another session may have removed the user already.
+	err := s.db().Update(u)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.record(change{cmd: "edit", name: before.Name, swaps: []storage.Swap{{Old: &before, New: &u}}})
+	return u, nil
+}
+
+// remove removes the user with the name given by the argument "name".
+func (s *session) remove(args values) (any, error) {
+	name := strings.TrimSpace(args.get("name"))
+	if name == "" {
+		return nil, badArgs("Usage: remove <name>")
+	}
+	users := s.db().Users()
+	i, ok := user.Slice(users).FindName(name)
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+
+	// Remove the user from the storage. Another session may have removed them already.
+	err := s.db().Delete(users[i].ID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	removed := users[i]
+	s.record(change{cmd: "remove", name: removed.Name, swaps: []storage.Swap{{Old: &removed}}})
+	return nil, nil
+}
+
+// show returns the users matching the filter of the session. The users are sorted
+// by the argument "sort" (see user.ParseOrder), and then the arguments "offset"
+// and "limit" select a page of them.
+func (s *session) show(args values) (any, error) {
+	var o user.Order
+	if sort := args.get("sort"); sort != "" {
+		var err error
+		if o, err = user.ParseOrder(sort); err != nil {
+			return nil, err
+		}
+	}
+	page, err := user.ParsePage(args.get("offset"), args.get("limit"))
+	if err != nil {
+		return nil, err
+	}
+	users := user.Slice(s.db().Users()).List(o, s.filter, page)
+	if users == nil {
+		users = user.Slice{}
+	}
+	return []user.User(users), nil
+}
+
+// find returns the users matching the query given by the argument "query".
+func (s *session) find(args values) (any, error) {
+	query := strings.TrimSpace(args.get("query"))
+	if query == "" {
+		return nil, badArgs("Usage: find <query>, like: find age>30 active=yes book~\"Potter\"")
+	}
+	p, err := user.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	users := user.Slice(s.db().Users()).Match(p)
+	if users == nil {
+		users = user.Slice{}
+	}
+	return []user.User(users), nil
+}
+
+// filterInfo is the data of the response to the filter command.
+type filterInfo struct {
+	Query string `json:"query"`
+}
+
+// setFilter sets the filter of the session to the query given by the argument "query".
+// Without the query, the filter is cleared.
+func (s *session) setFilter(args values) (any, error) {
+	query := strings.TrimSpace(args.get("query"))
+	if query == "" {
+		s.filter, s.filterQuery = nil, ""
+		return filterInfo{}, nil
+	}
+	p, err := user.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	s.filter, s.filterQuery = p, query
+	return filterInfo{Query: query}, nil
+}
+
+// get returns the user with the name given by the argument "name".
+func (s *session) get(args values) (any, error) {
+	name := strings.TrimSpace(args.get("name"))
+	if name == "" {
+		return nil, badArgs("Usage: get <name>")
+	}
+	users := s.db().Users()
+	i, ok := user.Slice(users).FindName(name)
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return users[i], nil
+}
+
+// books returns the average age of the readers per book, the oldest first.
+func (s *session) books(args values) (any, error) {
+	books := user.AvgAgeOfReadersPerBook(s.db().Users())
+	if books == nil {
+		books = user.AvgAgePerBookSlice{}
+	}
+	books.SortByAge()
+	return books, nil
+}
+
+// backups returns the backups of the storage, the newest first.
+func (s *session) backups(args values) (any, error) {
+	backups, err := s.strg.Backups()
+	if backups == nil {
+		backups = []storage.Backup{}
+	}
+	return backups, err
+}
+
+// restore replaces the users with the backup given by the argument "id".
+func (s *session) restore(args values) (any, error) {
+	id := args.get("id")
+	if id == "" {
+		return nil, badArgs("Usage: restore <id>. Enter \"backups\" to see the IDs.")
+	}
+	if s.tx != nil {
+		return nil, errInTx
+	}
+	if err := s.strg.Restore(id); err != nil {
+		return nil, err
+	}
+	// The changes made before can't be undone over the backup.
+	s.history = &history{}
+	return nil, nil
+}
@@ -0,0 +1,387 @@
+package tui
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/term"
+)
+
+const (
+	// maxHistory is the number of the lines kept in the history.
+	maxHistory = 1000
+	// histFilePerms are the permissions of the history file: the commands may have private data.
+	histFilePerms = 0600 // rw-------
+)
+
+// Editor is the LineReader of a terminal with the line editing, the history
+// and the completion. The terminal is in the raw mode only while a line is read.
+//
+// The keys are the ones of readline: Left, Right, Home, End, Backspace, Delete,
+// Ctrl-A, Ctrl-E, Ctrl-B, Ctrl-F, Ctrl-K, Ctrl-U and Ctrl-W edit the line; Up and
+// Down (or Ctrl-P and Ctrl-N) go through the history; Tab completes the word.
+// Ctrl-C, or Ctrl-D on the empty line, end the input with io.EOF.
+type Editor struct {
+	in  *bufio.Reader
+	out io.Writer
+	fd  int // the file descriptor of the terminal; -1 if the input isn't a terminal
+	// complete returns the candidates that replace the end of the line from start.
+	complete func(line string) (start int, candidates []string)
+
+	mu  sync.Mutex
+	raw *term.State // the state of the terminal to restore; nil if it isn't in the raw mode
+
+	prompt string // the output after the last newline
+
+	history  []string
+	histFile string // the file the history is saved to; "" if it isn't saved
+
+	// The line being read.
+	line    []rune
+	pos     int // the position of the cursor in the line
+	cursor  int // the position of the cursor on the screen, from the start of the line
+	histPos int // the position in the history; len(history) is the new line
+	draft   []rune
+}
+
+// NewEditor returns the editor of the terminal. The history is loaded from
+// the file and the lines added to it are saved there; the missing file is
+// created. The empty name means the history isn't saved.
+func NewEditor(in io.Reader, out io.Writer, historyFile string) (*Editor, error) {
+	e := &Editor{in: bufio.NewReader(in), out: out, fd: -1, histFile: historyFile}
+	if f, ok := in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		e.fd = int(f.Fd())
+	}
+	if historyFile != "" {
+		if err := e.loadHistory(); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// loadHistory reads the history file. If it is longer than maxHistory,
+// the file is rewritten with the last lines.
+func (e *Editor) loadHistory() error {
+	data, err := os.ReadFile(e.histFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't read history: %w", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+		data := strings.Join(e.history, "\n") + "\n"
+		if err := os.WriteFile(e.histFile, []byte(data), histFilePerms); err != nil {
+			return fmt.Errorf("couldn't write history: %w", err)
+		}
+	}
+	return nil
+}
+
+// AddHistory adds the line to the history, unless it is empty or the same as
+// the last one, and saves it to the history file.
+func (e *Editor) AddHistory(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" || len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[1:]
+	}
+	if e.histFile == "" {
+		return
+	}
+	f, err := os.OpenFile(e.histFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, histFilePerms)
+	if err == nil {
+		_, err = fmt.Fprintln(f, line)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		// The history is kept in memory only from now on.
+		log.Println("couldn't save history:", err)
+		e.histFile = ""
+	}
+}
+
+// Write writes the output of the session. It remembers the text after the last
+// newline, which is the prompt of the line being read.
+func (e *Editor) Write(p []byte) (int, error) {
+	if i := bytes.LastIndexByte(p, '\n'); i >= 0 {
+		e.prompt = string(p[i+1:])
+	} else {
+		e.prompt += string(p)
+	}
+	return e.out.Write(p)
+}
+
+// Close restores the terminal if the line is being read.
+func (e *Editor) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.raw == nil {
+		return nil
+	}
+	err := term.Restore(e.fd, e.raw)
+	e.raw = nil
+	return err
+}
+
+// ReadLine reads the line the user has edited.
+func (e *Editor) ReadLine() (string, error) {
+	if e.fd >= 0 {
+		e.mu.Lock()
+		raw, err := term.MakeRaw(e.fd)
+		e.raw = raw
+		e.mu.Unlock()
+		if err != nil {
+			return "", err
+		}
+		defer e.Close()
+	}
+	e.line, e.pos, e.cursor = e.line[:0], 0, 0
+	e.histPos, e.draft = len(e.history), nil
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err == io.EOF && len(e.line) > 0 {
+			e.newline()
+			return string(e.line), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			e.newline()
+			return string(e.line), nil
+		case ctrl('C'):
+			io.WriteString(e.out, "^C")
+			e.newline()
+			return "", io.EOF
+		case ctrl('D'):
+			if len(e.line) == 0 {
+				e.newline()
+				return "", io.EOF
+			}
+			e.deleteRange(e.pos, e.pos+1)
+		case ctrl('A'):
+			e.moveTo(0)
+		case ctrl('E'):
+			e.moveTo(len(e.line))
+		case ctrl('B'):
+			e.moveTo(e.pos - 1)
+		case ctrl('F'):
+			e.moveTo(e.pos + 1)
+		case ctrl('K'):
+			e.deleteRange(e.pos, len(e.line))
+		case ctrl('U'):
+			e.deleteRange(0, e.pos)
+		case ctrl('W'):
+			i := e.pos
+			for i > 0 && unicode.IsSpace(e.line[i-1]) {
+				i--
+			}
+			for i > 0 && !unicode.IsSpace(e.line[i-1]) {
+				i--
+			}
+			e.deleteRange(i, e.pos)
+		case ctrl('H'), 0x7f: // Backspace
+			if e.pos > 0 {
+				e.deleteRange(e.pos-1, e.pos)
+			}
+		case ctrl('P'):
+			e.recall(e.histPos - 1)
+		case ctrl('N'):
+			e.recall(e.histPos + 1)
+		case '\t':
+			e.completeLine()
+		case 0x1b: // Esc
+			e.escape()
+		default:
+			if unicode.IsPrint(r) {
+				e.insert(r)
+			}
+		}
+	}
+}
+
+func ctrl(r rune) rune {
+	return r & 0x1f
+}
+
+// escape handles the escape sequence of a key, like "\x1b[A" of Up.
+func (e *Editor) escape() {
+	r, _, err := e.in.ReadRune()
+	if err != nil || r != '[' && r != 'O' {
+		return
+	}
+	var seq []rune
+	for {
+		r, _, err = e.in.ReadRune()
+		if err != nil {
+			return
+		}
+		seq = append(seq, r)
+		if r >= 0x40 && r <= 0x7e {
+			break
+		}
+	}
+	switch string(seq) {
+	case "A":
+		e.recall(e.histPos - 1)
+	case "B":
+		e.recall(e.histPos + 1)
+	case "C":
+		e.moveTo(e.pos + 1)
+	case "D":
+		e.moveTo(e.pos - 1)
+	case "H", "1~", "7~":
+		e.moveTo(0)
+	case "F", "4~", "8~":
+		e.moveTo(len(e.line))
+	case "3~": // Delete
+		e.deleteRange(e.pos, e.pos+1)
+	}
+}
+
+func (e *Editor) insert(r rune) {
+	e.line = append(e.line, 0)
+	copy(e.line[e.pos+1:], e.line[e.pos:])
+	e.line[e.pos] = r
+	e.pos++
+	if e.pos == len(e.line) && e.cursor == e.pos-1 {
+		// The rune is typed at the end of the line, so there is nothing to redraw.
+		io.WriteString(e.out, string(r))
+		e.cursor++
+		return
+	}
+	e.refresh()
+}
+
+// deleteRange deletes the runes of the line from i to j.
+func (e *Editor) deleteRange(i, j int) {
+	j = min(j, len(e.line))
+	if i >= j {
+		return
+	}
+	e.line = append(e.line[:i], e.line[j:]...)
+	if e.pos > i {
+		e.pos = max(i, e.pos-(j-i))
+	}
+	e.refresh()
+}
+
+func (e *Editor) moveTo(pos int) {
+	if pos < 0 || pos > len(e.line) {
+		return
+	}
+	e.pos = pos
+	e.refresh()
+}
+
+// recall replaces the line with the line of the history at the position.
+// The position after the last line is the line being entered.
+func (e *Editor) recall(i int) {
+	if i < 0 || i > len(e.history) || i == e.histPos {
+		return
+	}
+	if e.histPos == len(e.history) {
+		e.draft = append(e.draft[:0], e.line...)
+	}
+	e.histPos = i
+	if i == len(e.history) {
+		e.line = append(e.line[:0], e.draft...)
+	} else {
+		e.line = append(e.line[:0], []rune(e.history[i])...)
+	}
+	e.pos = len(e.line)
+	e.refresh()
+}
+
+// completeLine completes the word before the cursor. If there are several
+// candidates and nothing to add to the word, the candidates are listed.
+func (e *Editor) completeLine() {
+	if e.complete == nil {
+		return
+	}
+	before := string(e.line[:e.pos])
+	start, candidates := e.complete(before)
+	if len(candidates) == 0 {
+		return
+	}
+	word, prefix := before[start:], commonPrefix(candidates)
+	if len(candidates) == 1 && !strings.HasSuffix(prefix, "=") {
+		prefix += " "
+	}
+	if prefix != word && len(prefix) >= len(word) {
+		completed := []rune(before[:start] + prefix)
+		e.line = append(completed, e.line[e.pos:]...)
+		e.pos = len(completed)
+		e.refresh()
+		return
+	}
+	if len(candidates) > 1 {
+		prompt := e.prompt
+		e.newline()
+		io.WriteString(e.out, strings.Join(candidates, "  "))
+		e.newline()
+		io.WriteString(e.out, prompt)
+		e.prompt = prompt
+		e.refresh()
+	}
+}
+
+// commonPrefix returns the longest common prefix of the strings.
+func commonPrefix(ss []string) string {
+	prefix := []rune(ss[0])
+	for _, s := range ss[1:] {
+		i := 0
+		for _, r := range s {
+			if i == len(prefix) || prefix[i] != r {
+				break
+			}
+			i++
+		}
+		prefix = prefix[:i]
+	}
+	return string(prefix)
+}
+
+// refresh redraws the line from the start and puts the cursor to its position.
+// The line is expected to fit the width of the terminal.
+func (e *Editor) refresh() {
+	var b strings.Builder
+	if e.cursor > 0 {
+		fmt.Fprintf(&b, "\x1b[%dD", e.cursor)
+	}
+	b.WriteString(string(e.line))
+	b.WriteString("\x1b[K")
+	if n := len(e.line) - e.pos; n > 0 {
+		fmt.Fprintf(&b, "\x1b[%dD", n)
+	}
+	e.cursor = e.pos
+	io.WriteString(e.out, b.String())
+}
+
+// newline moves the cursor to the next line; the raw mode doesn't do it for "\n".
+func (e *Editor) newline() {
+	io.WriteString(e.out, "\r\n")
+	e.prompt, e.cursor = "", 0
+}
@@ -0,0 +1,179 @@
+package tui
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"practice/internal/auth"
+	"practice/internal/storage"
+	"practice/internal/user"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEditor_ReadLine(t *testing.T) {
+	const (
+		up    = "\x1b[A"
+		down  = "\x1b[B"
+		left  = "\x1b[D"
+		right = "\x1b[C"
+		home  = "\x1b[H"
+		del   = "\x1b[3~"
+	)
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"plain", "show\r", "show"},
+		{"backspace", "shoe\x7fw\r", "show"},
+		{"insert", "sow" + left + left + "h\r", "show"},
+		{"home and delete", "xshow" + home + del + "\r", "show"},
+		{"ctrl", "get Ann\x01\x06\x06\x06\x0bshow\r", "getshow"},
+		{"kill word", "get Ann Lee\x17\x17Bob\r", "get Bob"},
+		{"kill line", "get Ann" + left + "\x15\r", "n"},
+		{"history", up + up + "\r", "get Ann"},
+		{"history past the start", up + up + up + up + "\r", "get Ann"},
+		{"history back to draft", "sh" + up + down + "ow\r", "show"},
+		{"history right", up + right + "!\r", "show!"},
+		{"utf-8", "get Ганна" + left + "\x7f\r", "get Гана"},
+		{"unknown sequence", "sh\x1b[5~ow\r", "show"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := NewEditor(strings.NewReader(tt.input), io.Discard, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.AddHistory("get Ann")
+			e.AddHistory("show")
+			got, err := e.ReadLine()
+			if err != nil || got != tt.want {
+				t.Errorf("ReadLine() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditor_EOF(t *testing.T) {
+	for _, input := range []string{"\x04", "get Ann\x03", ""} {
+		e, err := NewEditor(strings.NewReader(input), io.Discard, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line, err := e.ReadLine(); err != io.EOF {
+			t.Errorf("ReadLine(%q) = %q, %v, want %v", input, line, err, io.EOF)
+		}
+	}
+}
+
+func TestEditor_History(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+	e, err := NewEditor(strings.NewReader(""), io.Discard, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"show", "  show ", "", "get Ann", "quit"} {
+		e.AddHistory(line)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "show\nget Ann\nquit\n"; string(data) != want {
+		t.Errorf("history file = %q, want %q", data, want)
+	}
+
+	// The next session recalls the history, and the long history is cut.
+	for i := 0; i < maxHistory; i++ {
+		e.AddHistory(strings.Repeat("x", i%2+1))
+	}
+	e, err = NewEditor(strings.NewReader("\x1b[A\r"), io.Discard, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := e.ReadLine(); got != "xx" {
+		t.Errorf("ReadLine() = %q, want %q", got, "xx")
+	}
+	if n := len(e.history); n != maxHistory {
+		t.Errorf("got %d lines of history, want %d", n, maxHistory)
+	}
+	if data, _ = os.ReadFile(file); strings.Count(string(data), "\n") != maxHistory {
+		t.Errorf("the history file isn't cut:\n%s", data)
+	}
+}
+
+func TestEditor_Complete(t *testing.T) {
+	strg := storage.New(storage.NewMemory(
+		user.User{ID: 1, Name: "Jane Doe"},
+		user.User{ID: 2, Name: "Jake"},
+		user.User{ID: 3, Name: "Bob"},
+	))
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+
+	tests := []struct {
+		name  string
+		input string
+		want  string // the line read
+		out   string // a substring of the output
+	}{
+		{"command", "sh\t\r", "show ", ""},
+		{"command case", "RES\t\r", "restore ", ""},
+		{"commands", "re\t\t\r", "re", "remove  restore\r\n"},
+		{"user", "get b\t\r", "get Bob ", ""},
+		{"users", "edit j\t\t\r", "edit Ja", "Jake  Jane Doe\r\n"},
+		{"user with space", "remove Jan\t\r", "remove Jane Doe ", ""},
+		{"option", "show so\t\r", "show sort=", ""},
+		{"option after user", "edit Bob a\t\t\r", "edit Bob a", "age=  active=  add_books=\r\n"},
+		{"no candidates", "bogus x\t\r", "bogus x", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			e, err := NewEditor(strings.NewReader(tt.input), &out, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := newSession(nil, nil, strg, []Option{WithEditor(e)})
+			s.role = auth.RoleWrite
+			got, err := s.readLine()
+			if err != nil || got != tt.want {
+				t.Errorf("readLine() = %q, %v, want %q", got, err, tt.want)
+			}
+			if !strings.Contains(out.String(), tt.out) {
+				t.Errorf("output doesn't contain %q:\n%q", tt.out, out.String())
+			}
+		})
+	}
+}
+
+func TestPrompt_Editor(t *testing.T) {
+	strg := storage.New(storage.NewMemory(user.User{ID: 1, Name: "Ann"}))
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+	file := filepath.Join(t.TempDir(), "history")
+
+	// The answers to the prompts of the commands don't get to the history.
+	input := "add\rBob\r30\ryes\r70\rDune\r\rrem\tAnn\r"
+	var out strings.Builder
+	e, err := NewEditor(strings.NewReader(input), &out, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Prompt(nil, nil, strg, auth.RoleWrite, WithEditor(e)); err != ErrEndOfSession {
+		t.Errorf("Prompt() error = %v, want %v", err, ErrEndOfSession)
+	}
+	want := []user.User{{ID: 2, Name: "Bob", Age: 30, Active: true, Mass: 70, Books: []string{"Dune"}}}
+	if got := strg.Users(); !reflect.DeepEqual(got, want) {
+		t.Errorf("users = %+v, want %+v", got, want)
+	}
+	if data, _ := os.ReadFile(file); string(data) != "add\nremove Ann\n" {
+		t.Errorf("history file = %q, want %q", data, "add\nremove Ann\n")
+	}
+}
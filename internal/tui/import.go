@@ -0,0 +1,132 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"practice/internal/importer"
+	"practice/internal/storage"
+	"practice/internal/user"
+	"strings"
+)
+
+// errCanceled is returned by ask if the user cancels the command.
+var errCanceled = errors.New("canceled")
+
+// importInfo is the data of the response to import.
+type importInfo struct {
+	DryRun    bool             `json:"dry_run"`
+	Added     int              `json:"added"`
+	Updated   int              `json:"updated"`
+	Unchanged int              `json:"unchanged"`
+	Rejected  []rejectedRecord `json:"rejected"`
+
+	plan *importer.Plan
+}
+
+type rejectedRecord struct {
+	Record int    `json:"record"`
+	Error  string `json:"error"`
+}
+
+// importUsers imports the users from the file given by the argument "file"
+// (see package importer). The format is given by the argument "format", or by
+// the extension of the file. With the argument "dry_run", nothing is imported.
+func (s *session) importUsers(args values) (any, error) {
+	dryRun, err := parseYesNo(args, "dry_run")
+	if err != nil {
+		return nil, err
+	}
+	if s.tx != nil {
+		return nil, errInTx
+	}
+	p, err := s.importPlan(args)
+	if err != nil {
+		return nil, err
+	}
+	info := importInfo{DryRun: dryRun, Added: len(p.Added), Updated: len(p.Updated), Unchanged: len(p.Unchanged),
+		Rejected: []rejectedRecord{}, plan: p}
+	for _, rec := range p.Rejected {
+		info.Rejected = append(info.Rejected, rejectedRecord{Record: rec.N, Error: rec.Err.Error()})
+	}
+	if dryRun {
+		return info, nil
+	}
+
+	err = p.Apply(s.strg)
+	if errors.Is(err, storage.ErrConflict) {
+		return nil, &Error{Code: CodeConflict, Message: "Another session has changed the users during the import. Try again."}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if swaps := p.Swaps(); len(swaps) > 0 {
+		s.record(change{cmd: "import", swaps: swaps})
+	}
+	return info, nil
+}
+
+// importPlan reads the file given by the arguments and returns what importing it makes.
+func (s *session) importPlan(args values) (*importer.Plan, error) {
+	path := strings.TrimSpace(args.get("file"))
+	if path == "" {
+		return nil, badArgs("Usage: import <file> [format=csv|json] [dry_run=yes]")
+	}
+	format := strings.ToLower(strings.TrimSpace(args.get("format")))
+	if format == "" {
+		var err error
+		if format, err = importer.FormatOf(path); err != nil {
+			return nil, badArgs("%v; give format=csv or format=json", err)
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, badArgs("Couldn't open the file: %v", err)
+	}
+	defer f.Close()
+	records, err := importer.Read(f, format)
+	if err != nil {
+		return nil, badArgs("Couldn't import %s: %v", path, err)
+	}
+	return importer.NewPlan(s.strg.Users(), records), nil
+}
+
+// parseYesNo parses the argument that is yes or no; the missing one is no.
+func parseYesNo(args values, name string) (bool, error) {
+	v, err := user.ParseYesNo(args.get(name))
+	if err != nil {
+		return false, badArgs("The %s must be yes or no: %q", name, strings.TrimSpace(args.get(name)))
+	}
+	return v, nil
+}
+
+// askImport prints what importing the file makes and asks to confirm it.
+func (s *session) askImport(args values) error {
+	p, err := s.importPlan(args)
+	if err != nil || s.tx != nil {
+		return nil // importUsers reports it
+	}
+	p.WriteSummary(s.w)
+	if len(p.Swaps()) == 0 {
+		return nil
+	}
+	fmt.Fprint(s.w, "Import the users? [yes/no]: ")
+	input, err := s.readLine()
+	if err != nil {
+		return fmt.Errorf("couldn't read answer: %w", err)
+	}
+	if yes, err := user.ParseYesNo(input); err == nil && yes {
+		return nil
+	}
+	return errCanceled
+}
+
+func (s *session) printImport(data any) {
+	info := data.(importInfo)
+	if info.DryRun {
+		info.plan.WriteSummary(s.w)
+		fmt.Fprintln(s.w, "Dry run: nothing is imported.")
+		return
+	}
+	info.plan.WriteResult(s.w)
+}
@@ -0,0 +1,117 @@
+package tui
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"practice/internal/auth"
+	"practice/internal/storage"
+	"practice/internal/user"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunScript_Import(t *testing.T) {
+	ann := user.User{ID: 1, Name: "Ann", Age: 30, Books: []string{"Dune"}}
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "users.csv")
+	if err := os.WriteFile(csvFile, []byte("name,age,mass\nAnn,31,\nBob,old,\nCarl,20,62.5\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	txtFile := filepath.Join(dir, "users.txt")
+	if err := os.WriteFile(txtFile, []byte(`[{"name": "Dan"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	imported := []user.User{{ID: 1, Name: "Ann", Age: 31, Books: []string{"Dune"}}, {ID: 2, Name: "Carl", Age: 20, Mass: 62.5}}
+
+	tests := []struct {
+		name    string
+		script  string
+		wantErr error
+		want    []user.User
+		out     []string // the substrings of the output
+	}{
+		{
+			name:   "import",
+			script: "import " + csvFile + "\n",
+			want:   imported,
+			out:    []string{"Imported: 1 added, 1 updated, 0 unchanged, 1 rejected", `Record 2: invalid age "old"`},
+		},
+		{
+			name:   "dry run",
+			script: `import "` + csvFile + `" dry_run=yes` + "\n",
+			want:   []user.User{ann},
+			out:    []string{"To add: 1 (Carl)\nTo update: 1 (Ann)\nUnchanged: 0\nRejected: 1", "Dry run: nothing is imported."},
+		},
+		{
+			name:   "undo",
+			script: "import " + csvFile + "\nundo\n",
+			want:   []user.User{ann},
+			out:    []string{"Undone: import of 2 users"},
+		},
+		{
+			name:   "format",
+			script: "import " + txtFile + " format=json\n",
+			want:   []user.User{ann, {ID: 2, Name: "Dan"}},
+			out:    []string{"Imported: 1 added"},
+		},
+		{
+			name:    "unknown format",
+			script:  "import " + txtFile + "\n",
+			wantErr: ErrScriptFailed,
+			want:    []user.User{ann},
+			out:     []string{"give format=csv or format=json"},
+		},
+		{
+			name:    "transaction",
+			script:  "begin\nimport " + csvFile + "\n",
+			wantErr: ErrScriptFailed,
+			want:    []user.User{ann},
+			out:     []string{"Line 2: The command can't be used in the transaction"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strg := storage.New(storage.NewMemory(ann))
+			if err := strg.Load(); err != nil {
+				t.Fatal(err)
+			}
+			defer strg.Close()
+
+			var out strings.Builder
+			err := RunScript(&out, strings.NewReader(tt.script), strg, auth.RoleWrite)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("RunScript() error = %v, want %v", err, tt.wantErr)
+			}
+			for _, s := range tt.out {
+				if !strings.Contains(out.String(), s) {
+					t.Errorf("output doesn't contain %q:\n%s", s, out.String())
+				}
+			}
+			if got := strg.Users(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("users = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServe_ImportIsLocal(t *testing.T) {
+	strg := storage.New(storage.NewMemory())
+	if err := strg.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer strg.Close()
+
+	var out strings.Builder
+	err := Serve(&out, strings.NewReader("help\nimport users.csv\n"), strg, nil)
+	if err != ErrEndOfSession {
+		t.Errorf("Serve() error = %v, want %v", err, ErrEndOfSession)
+	}
+	if strings.Contains(out.String(), "Imports the users") {
+		t.Errorf("help lists import:\n%s", out.String())
+	}
+	if want := `"import" is available in the local prompt only`; !strings.Contains(out.String(), want) {
+		t.Errorf("output doesn't contain %q:\n%s", want, out.String())
+	}
+}
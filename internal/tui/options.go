@@ -0,0 +1,118 @@
+package tui
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"practice/internal/storage"
+)
+
+var (
+	ErrLineTooLong     = errors.New("line is too long")
+	ErrTooManyCommands = errors.New("too many commands, try again later")
+)
+
+// LineReader reads the lines of the input of a session.
+type LineReader interface {
+	// ReadLine returns the line without the newline.
+	ReadLine() (string, error)
+}
+
+// plainReader is the LineReader of the input without the line editing.
+type plainReader struct {
+	r   *bufio.Reader
+	max int // the maximum length of the line; 0 means no limit
+}
+
+func (p *plainReader) ReadLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := p.r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if p.max > 0 && len(bytes.TrimSuffix(line, []byte("\n"))) > p.max {
+			return "", ErrLineTooLong
+		}
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == nil:
+			return string(line[:len(line)-1]), nil
+		case err == io.EOF && len(line) > 0:
+			return string(line), nil
+		}
+		return "", err
+	}
+}
+
+// Limits limit a session, like with the deadlines of the connection
+// or the rate of the commands.
+type Limits interface {
+	// Wait is called before waiting for the next command or the login.
+	Wait()
+	// Command is called before running the command. If it returns an error,
+	// the command is rejected with it.
+	Command(name string) error
+}
+
+// noLimits are the Limits of the session without the limits.
+type noLimits struct{}
+
+func (noLimits) Wait()                {}
+func (noLimits) Command(string) error { return nil }
+
+// Option configures the session.
+type Option func(*session)
+
+func newSession(w io.Writer, r io.Reader, strg *storage.Storage, opts []Option) *session {
+	s := &session{w: w, strg: strg, limits: noLimits{}, history: &history{}}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.in == nil {
+		s.in = &plainReader{r: bufio.NewReader(r), max: s.maxLine}
+	}
+	return s
+}
+
+// WithLimits limits the session. By default, there are no limits.
+func WithLimits(l Limits) Option {
+	return func(s *session) {
+		s.limits = l
+	}
+}
+
+// WithMaxLineLength limits the length of the input lines in bytes.
+// The session ends with ErrLineTooLong on a longer line. By default, there is no limit.
+func WithMaxLineLength(n int) Option {
+	return func(s *session) {
+		s.maxLine = n
+	}
+}
+
+// WithContinueOnError makes the script go on after a failed command
+// (see RunScript). By default, the script stops.
+func WithContinueOnError() Option {
+	return func(s *session) {
+		s.keepGoing = true
+	}
+}
+
+// WithEditor reads the lines of the session with the editor, which completes
+// the commands and the names of the users. The output of the session is written
+// through the editor, so it knows the prompts.
+func WithEditor(e *Editor) Option {
+	return func(s *session) {
+		s.in, s.w = e, e
+		e.complete = s.complete
+	}
+}
+
+// WithHistories keeps the undo history of the session in the histories after
+// it ends, so the next session of the same user may undo the changes. The history
+// of the session that doesn't log in ends with it, like by default.
+func WithHistories(h *Histories) Option {
+	return func(s *session) {
+		s.histories = h
+	}
+}
@@ -2,81 +2,440 @@
 package tui
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"os"
+	"practice/internal/auth"
 	"practice/internal/storage"
 	"practice/internal/table"
 	"practice/internal/user"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/exp/slices"
 )
 
 var (
 	ErrEndOfSession = errors.New("end of session")
 	ErrUserNotFound = errors.New("user is not found")
+	ErrLoginFailed  = errors.New("login failed")
+	ErrScriptFailed = errors.New("script failed")
 )
 
-func Prompt(w io.Writer, r io.Reader, strg *storage.Storage, users *[]user.User) error {
-	fmt.Fprintln(w, "Enter \"help\" for usage hints.")
+// maxLoginAttempts is the number of the attempts to log in.
+const maxLoginAttempts = 3
+
+// session is a session of the text user interface.
+type session struct {
+	w    io.Writer
+	in   LineReader
+	strg *storage.Storage
+	role auth.Role
+	// users authenticate the session; nil if the session doesn't need logging in.
+	users    *auth.Store
+	loggedIn bool
+	name     string // the name of the user logged in; "" if the session doesn't log in
+	limits   Limits
+	maxLine  int
+	remote   bool // the session is served to a client, so it can't use the local files
+	// filter limits the users printed by show; nil if there is no filter.
+	filter      user.Predicate
+	filterQuery string
+	// script is set if the session runs a script (see RunScript).
+	script    bool
+	keepGoing bool // the script goes on after a failed command
+	line      int  // the number of the lines read
+	failed    int  // the number of the failed commands
+	// history is the history of the changes to undo and redo.
+	history *history
+	// histories keep the history after the session for the next session
+	// of the user; nil if the history ends with the session.
+	histories *Histories
+	// tx is the transaction begun by the session; nil if there is none.
+	// It is rolled back if the session ends.
+	tx *tx
+}
+
+// Prompt runs the session of the text user interface. The commands that change
+// the database are denied unless the role is auth.RoleWrite.
+func Prompt(w io.Writer, r io.Reader, strg *storage.Storage, role auth.Role, opts ...Option) error {
+	s := newSession(w, r, strg, opts)
+	s.role, s.loggedIn = role, true
+	return s.prompt()
+}
+
+// Serve runs the session of a client. If users isn't nil, the session starts
+// with logging in, and the role of the user limits the commands. The client may
+// switch the session to the JSON protocol (see Request) by entering "proto json"
+// instead of the name or a command.
+func Serve(w io.Writer, r io.Reader, strg *storage.Storage, users *auth.Store, opts ...Option) error {
+	s := newSession(w, r, strg, opts)
+	s.role, s.users, s.loggedIn, s.remote = auth.RoleWrite, users, users == nil, true
+	defer s.keepHistory()
+	if !s.loggedIn {
+		err := s.login()
+		if err == errProtoJSON {
+			return s.serveJSON()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return s.prompt()
+}
+
+// RunScript runs the commands of the script read from r, one per line, like
+// `add name="Jane Doe" age=31`, and writes their output to w. The empty lines
+// and the lines starting with # are skipped. The commands don't prompt for
+// the missing arguments. The script stops on the first failed command with
+// ErrScriptFailed; with WithContinueOnError, it runs to the end and then
+// returns ErrScriptFailed if any command failed.
+func RunScript(w io.Writer, r io.Reader, strg *storage.Storage, role auth.Role, opts ...Option) error {
+	s := newSession(w, r, strg, opts)
+	s.role, s.loggedIn, s.script = role, true, true
+	err := s.prompt()
+	if err == ErrEndOfSession {
+		err = nil
+	}
+	if err == nil && s.failed > 0 {
+		err = fmt.Errorf("%w: %d of the commands failed", ErrScriptFailed, s.failed)
+	}
+	return err
+}
+
+// prompt reads the commands until the session ends.
+func (s *session) prompt() error {
+	if !s.script {
+		fmt.Fprintln(s.w, "Enter \"help\" for usage hints.")
+	}
 
 	for {
-		fmt.Fprintf(w, "%s > ", strg.Name())
+		if !s.script {
+			if s.tx != nil {
+				fmt.Fprintf(s.w, "%s (transaction) > ", s.strg.Name())
+			} else {
+				fmt.Fprintf(s.w, "%s > ", s.strg.Name())
+			}
+		}
 
-		var in string
-		fmt.Fscanf(r, "%s", &in)
+		s.limits.Wait()
+		line, err := s.readLine()
+		if err == io.EOF {
+			return ErrEndOfSession
+		}
+		if err == ErrLineTooLong {
+			fmt.Fprintf(s.w, "\nThe line is longer than %d bytes. Bye.\n", s.maxLine)
+		}
+		if err != nil {
+			return err
+		}
+		in, rest := cutField(line)
+		if in == "" || s.script && strings.HasPrefix(in, "#") {
+			continue
+		}
+		if e, ok := s.in.(*Editor); ok {
+			e.AddHistory(line)
+		}
 
-		switch strings.ToUpper(in) {
-		case "ADD":
-			if err := addUser(w, r, strg, users); err != nil {
-				log.Println("failed to add user:", err)
+		failed := s.failed
+		cmd, ok := lookup(in)
+		switch {
+		case !ok:
+			s.fail("Unknown operator %q. Enter \"help\" for usage hints.", in)
+		case cmd.write && s.role != auth.RoleWrite:
+			s.fail("Permission denied: %q needs the write access.", cmd.name)
+		case cmd.local && s.remote:
+			s.fail("%q is available in the local prompt only.", cmd.name)
+		default:
+			if err = s.limits.Command(cmd.name); err != nil {
+				s.fail("%s", toError(err).Message)
+				continue
 			}
-		case "REMOVE":
-			err := rmUser(w, r, strg, users)
-			switch {
-			case err == ErrUserNotFound:
-				fmt.Fprintln(w, err)
-			case err != nil:
-				log.Println("failed to remove user:", err)
-			default:
-				fmt.Fprintln(w, "User deleted")
+			err = s.run(cmd, rest)
+			if err == errProtoJSON {
+				return s.serveJSON()
 			}
-		case "SHOW":
-			show(w, *users)
-		case "HELP":
-			printHelp(w)
-		case "QUIT":
-			return ErrEndOfSession
+			if err != nil {
+				return err
+			}
+		}
+		if s.script && s.failed > failed && !s.keepGoing {
+			return fmt.Errorf("%w: line %d", ErrScriptFailed, s.line)
+		}
+	}
+}
+
+// fail prints the message of the failed command. In the script, the message
+// starts with the number of the line.
+func (s *session) fail(format string, a ...any) {
+	s.failed++
+	if s.script {
+		fmt.Fprintf(s.w, "Line %d: ", s.line)
+	}
+	fmt.Fprintf(s.w, format+"\n", a...)
+}
+
+// run runs the command with the arguments of the prompt given by the rest
+// of the line, and prints the result. Only the errors that end the session
+// are returned.
+func (s *session) run(cmd command, rest string) error {
+	args := values{}
+	for i, name := range cmd.params {
+		var arg string
+		switch {
+		case i < len(cmd.params)-1:
+			arg, rest = cutField(rest)
+		case cmd.options == nil:
+			// The last argument takes the rest of the line as is, so it may have spaces.
+			arg, rest = strings.TrimSpace(rest), ""
 		default:
-			fmt.Fprintf(w, "Unknown operator %q. Enter \"help\" for usage hints.\n", in)
+			// The last argument takes the words up to the options; it may be quoted.
+			arg, rest = cutOptions(rest, cmd.options)
+			var err error
+			if arg, err = user.Unquote(arg); err != nil {
+				s.fail("%s", toError(err).Message)
+				return nil
+			}
+		}
+		if arg == "" {
+			break
+		}
+		args.set(name, arg)
+	}
+	options := strings.TrimSpace(rest) != ""
+	if cmd.options != nil {
+		if err := parseOptions(args, rest, cmd.options); err != nil {
+			s.fail("%s", toError(err).Message)
+			return nil
+		}
+	}
+	// The command given with the options, or in the script, doesn't prompt.
+	if cmd.ask != nil && !options && !s.script {
+		err := cmd.ask(s, args)
+		switch {
+		case errors.Is(err, ErrLineTooLong), errors.Is(err, os.ErrDeadlineExceeded):
+			return err
+		case err == errCanceled:
+			fmt.Fprintln(s.w, "Canceled")
+			return nil
+		case err != nil:
+			s.failed++
+			log.Printf("failed to %s: %v", cmd.name, err)
+			return nil
+		}
+	}
+
+	data, err := cmd.exec(s, args)
+	switch {
+	case err == ErrEndOfSession, err == errProtoJSON:
+		return err
+	case err != nil:
+		if e := toError(err); e.Code != CodeInternal {
+			s.fail("%s", e.Message)
+		} else {
+			s.failed++
+			log.Printf("failed to %s: %v", cmd.name, err)
+		}
+	case cmd.print != nil:
+		cmd.print(s, data)
+	}
+	return nil
+}
+
+// login asks for the name and the password until the user is authenticated,
+// and sets the role of the session. After maxLoginAttempts failed attempts,
+// ErrLoginFailed is returned. If "proto json" is entered instead of the name,
+// errProtoJSON is returned.
+func (s *session) login() error {
+	for i := 0; i < maxLoginAttempts; i++ {
+		fmt.Fprint(s.w, "Login: ")
+		s.limits.Wait()
+		name, err := s.readLine()
+		if err != nil {
+			return err
+		}
+		if isProtoJSON(name) {
+			return errProtoJSON
+		}
+		fmt.Fprint(s.w, "Password: ")
+		password, err := s.readLine()
+		if err != nil {
+			return err
+		}
+		if err = s.limits.Command("login"); err != nil {
+			fmt.Fprintln(s.w, toError(err).Message)
+			continue
+		}
+		role, err := s.users.Authenticate(strings.TrimSpace(name), strings.TrimSuffix(password, "\r"))
+		if err == nil {
+			fmt.Fprintf(s.w, "Welcome, %s! Your access: %s.\n", strings.TrimSpace(name), role)
+			s.loginAs(strings.TrimSpace(name), role)
+			return nil
+		}
+		fmt.Fprintln(s.w, err)
+	}
+	fmt.Fprintln(s.w, "Too many failed attempts. Bye.")
+	return ErrLoginFailed
+}
+
+// loginAs sets the user of the session and continues their history
+// from the previous session.
+func (s *session) loginAs(name string, role auth.Role) {
+	if s.loggedIn {
+		// The session logs in as another user, so the history stays with the previous one.
+		s.keepHistory()
+		s.history = &history{}
+	}
+	s.name, s.role, s.loggedIn = name, role, true
+	s.takeHistory()
+}
+
+// cutField returns the first field of the line and the rest of it.
+func cutField(line string) (field, rest string) {
+	line = strings.TrimLeftFunc(line, unicode.IsSpace)
+	i := strings.IndexFunc(line, unicode.IsSpace)
+	if i < 0 {
+		return line, ""
+	}
+	return line[:i], line[i:]
+}
+
+// listOptions are the options that are lists separated by semicolons, like books="Dune;Emma".
+var listOptions = []string{"books", "add_books", "remove_books"}
+
+// parseOptions adds the options of the line given as name=value, like
+// `sort=age desc limit=10`, to the arguments. The words without "=" continue
+// the value of the previous option. A value may be quoted like a Go string.
+func parseOptions(args values, line string, names []string) error {
+	var name string
+	for {
+		field, rest, err := user.CutQuoted(line)
+		if err != nil {
+			return err
+		}
+		if field == "" {
+			break
+		}
+		line = rest
+
+		if i := strings.IndexByte(field, '='); i > 0 && field[0] != '"' {
+			name = strings.ToLower(field[:i])
+			if !slices.Contains(names, name) {
+				return badArgs("Unknown option %q; the options are: %s", name, strings.Join(names, ", "))
+			}
+			field = field[i+1:]
+			args.set(name, "")
+		} else if name == "" {
+			return badArgs("Expected an option like %s=<value>, got %q", names[0], field)
 		}
+		value, err := user.Unquote(field)
+		if err != nil {
+			return err
+		}
+		if v := args.get(name); v != "" {
+			value = v + " " + value
+		}
+		args.set(name, value)
+	}
+	for _, name := range listOptions {
+		if _, ok := args[name]; ok && slices.Contains(names, name) {
+			args.set(name, strings.Split(args.get(name), ";")...)
+		}
+	}
+	return nil
+}
+
+// cutOptions cuts the line before the first option of the names.
+func cutOptions(line string, names []string) (before, options string) {
+	rest := line
+	for {
+		field, after, err := user.CutQuoted(rest)
+		if err != nil || field == "" {
+			return strings.TrimSpace(line), ""
+		}
+		if i := strings.IndexByte(field, '='); i > 0 && field[0] != '"' && slices.Contains(names, strings.ToLower(field[:i])) {
+			n := len(line) - len(strings.TrimLeftFunc(rest, unicode.IsSpace))
+			return strings.TrimSpace(line[:n]), line[n:]
+		}
+		rest = after
+	}
+}
+
+// isProtoJSON reports whether the line is the "proto json" command.
+func isProtoJSON(line string) bool {
+	fields := strings.Fields(line)
+	return len(fields) == 2 && strings.EqualFold(fields[0], "proto") && strings.EqualFold(fields[1], "json")
+}
+
+// printText returns the print function that prints the text.
+func printText(text string) func(s *session, data any) {
+	return func(s *session, data any) {
+		fmt.Fprintln(s.w, text)
+	}
+}
+
+func (s *session) printHelp(data any) {
+	entries := data.([]helpEntry)
+	usages := make([]string, len(entries))
+	width := 0
+	for i, e := range entries {
+		usages[i] = strings.TrimSpace(e.Command + " " + e.Args)
+		width = max(width, len(usages[i]))
+	}
+	for i, e := range entries {
+		fmt.Fprintf(s.w, "%-*s  %s\n", width, usages[i], e.Help)
+	}
+}
+
+// printUsers prints the users or the user.
+func (s *session) printUsers(data any) {
+	users, ok := data.([]user.User)
+	if !ok {
+		users = []user.User{data.(user.User)}
+	}
+	table.PrintData(s.w, user.Slice(users), user.Headers)
+	fmt.Fprintln(s.w, "Number of active users:", user.Slice(users).NumOfActiveUsers())
+}
+
+func (s *session) printFilter(data any) {
+	if q := data.(filterInfo).Query; q != "" {
+		fmt.Fprintf(s.w, "Filter: %s. Enter \"filter\" to clear it.\n", q)
+		return
 	}
+	fmt.Fprintln(s.w, "Filter cleared")
 }
 
-func printHelp(w io.Writer) {
-	fmt.Fprintln(
-		w,
-		`add     Adds user to the database
-help    Show help
-quit    Exit this program
-remove  Removes the user from the database
-show    Prints the contents of the table`,
-	)
+func (s *session) printBooks(data any) {
+	table.PrintData(s.w, data.(user.AvgAgePerBookSlice), user.BookHeaders)
 }
 
-// addUser adds a new user to the slice of users and writes them to the storage.
-func addUser(w io.Writer, r io.Reader, strg *storage.Storage, users *[]user.User) error {
-	// Check if there is space for a new user.
-	if len(*users) > user.MaxNumOfUsers {
-		return errors.New("no free slots for a new user")
+// printBackups prints the backups, the newest first.
+func (s *session) printBackups(data any) {
+	backups := data.([]storage.Backup)
+	if len(backups) == 0 {
+		fmt.Fprintln(s.w, "No backups yet")
+		return
+	}
+	for _, b := range backups {
+		fmt.Fprintf(s.w, "%s  %s  %d bytes\n", b.ID, b.Time.Local().Format(time.DateTime), b.Size)
 	}
+}
 
-	// Read the new user's data.
-	rb := bufio.NewReader(r)
+// readLine reads a line of the session.
+func (s *session) readLine() (string, error) {
+	s.line++
+	return s.in.ReadLine()
+}
+
+// askUser prompts for the new user's data.
+func (s *session) askUser(args values) error {
+	r := s.readLine
 	// - name:
-	name, err := promptUserName(w, rb)
+	name, err := promptUserName(s.w, r)
 	if err != nil {
 		return err
 	}
@@ -84,133 +443,100 @@ func addUser(w io.Writer, r io.Reader, strg *storage.Storage, users *[]user.User
 		return errors.New("no name is entered")
 	}
 	// - age:
-	age, err := promptUserAge(w, rb)
+	age, err := promptUserAge(s.w, r)
 	if err != nil {
 		return err
 	}
-	// - active index/status:
-	activeIndex, err := promptUserActiveStatus(w, rb, *users)
+	// - active status:
+	active, err := promptUserActiveStatus(s.w, r)
 	if err != nil {
 		return err
 	}
 	// - mass:
-	mass, err := promptUserMass(w, rb)
+	mass, err := promptUserMass(s.w, r)
 	if err != nil {
 		return err
 	}
 	// - books:
 	var books []string
-	if err = promptUserBooks(w, rb, &books); err != nil {
-		return err
-	}
-
-	// Add a new user to the users.
-	newUser := user.User{
-		Name:        name,
-		Age:         age,
-		ActiveIndex: activeIndex,
-		Mass:        mass,
-		Books:       books,
-	}
-	*users = append(*users, newUser)
-
-	// Save a new user to the storage.
-	if err = user.EncodeUser(strg.Writer(), newUser); err != nil {
-		return err
-	}
-	if err = strg.Sync(); err != nil {
+	if err = promptUserBooks(s.w, r, &books); err != nil {
 		return err
 	}
 
+	args.set("name", name)
+	args.set("age", strconv.Itoa(int(age)))
+	args.set("active", strconv.FormatBool(active))
+	args.set("mass", strconv.FormatFloat(mass, 'g', -1, 64))
+	args.set("books", books...)
 	return nil
 }
 
 // promptUserName prompts for a name of a new user.
-func promptUserName(w io.Writer, r *bufio.Reader) (input string, err error) {
+func promptUserName(w io.Writer, read func() (string, error)) (input string, err error) {
 	fmt.Fprint(w, "Enter name: ")
 
-	input, err = r.ReadString('\n')
+	input, err = read()
 	if err != nil {
-		return "", fmt.Errorf("couldn't read name: %v", err)
+		return "", fmt.Errorf("couldn't read name: %w", err)
 	}
 
 	return strings.TrimSpace(input), nil
 }
 
 // promptUserAge prompts for an age of a new user.
-func promptUserAge(w io.Writer, r *bufio.Reader) (uint8, error) {
+func promptUserAge(w io.Writer, read func() (string, error)) (uint8, error) {
 	fmt.Fprint(w, "Enter age: ")
 
-	input, err := r.ReadString('\n')
+	input, err := read()
 	if err != nil {
-		return 0, fmt.Errorf("couldn't read age: %v", err)
+		return 0, fmt.Errorf("couldn't read age: %w", err)
 	}
-	input = strings.TrimSpace(input)
 
-	if input == "" {
-		return 0, nil
-	}
-	age, err := strconv.ParseUint(input, 10, 8)
-	if err != nil {
-		return 0, err
-	}
-
-	return uint8(age), nil
+	return user.ParseAge(input)
 }
 
-// promptUserActiveStatus prompts if a new user is active and generates the activeStatus index.
-func promptUserActiveStatus(w io.Writer, r *bufio.Reader, users []user.User) (activeStatus uint8, err error) {
+// promptUserActiveStatus prompts if a new user is active.
+func promptUserActiveStatus(w io.Writer, read func() (string, error)) (active bool, err error) {
 	fmt.Fprint(w, "Is the user is active now? [yes/no]: ")
 
-	input, err := r.ReadString('\n')
+	input, err := read()
 	if err != nil {
-		return 0, fmt.Errorf("couldn't read active status: %v", err)
+		return false, fmt.Errorf("couldn't read active status: %w", err)
 	}
-	input = strings.TrimSpace(input)
 
-	switch strings.ToUpper(input) {
-	case "YES", "Y":
-		activeStatus = 1
-	case "NO", "N", "":
-		activeStatus = 0
-	default:
+	active, err = user.ParseActive(input)
+	if err != nil {
 		fmt.Fprint(w, "Please, provide with [yes/no], [YyNn].")
-		return promptUserActiveStatus(w, r, users)
+		return promptUserActiveStatus(w, read)
 	}
-	// TODO: improve the activeStatus index generation to avoid duplicates.
-	activeStatus <<= len(users)
 
-	return activeStatus, nil
+	return active, nil
 }
 
 // promptUserMass prompts for a mass of a new user.
-func promptUserMass(w io.Writer, r *bufio.Reader) (mass float64, err error) {
+func promptUserMass(w io.Writer, read func() (string, error)) (mass float64, err error) {
 	fmt.Fprint(w, "Enter the user's mass: ")
 
-	input, err := r.ReadString('\n')
+	input, err := read()
 	if err != nil {
-		return 0.0, fmt.Errorf("couldn't read mass: %v", err)
+		return 0.0, fmt.Errorf("couldn't read mass: %w", err)
 	}
-	input = strings.TrimSpace(input)
 
-	mass, err = strconv.ParseFloat(input, 64)
+	mass, err = user.ParseMass(input)
 	if err != nil {
-		return 0.0, fmt.Errorf("couldn't read mass: %v", err)
-	}
-	if mass < 0.0 {
-		mass = 0.0
+		return 0.0, fmt.Errorf("couldn't read mass: %w", err)
 	}
 
-	return user.VerifyMass(mass), nil
+	return mass, nil
 }
 
 // promptUserBooks prompts for a list of books a new user has read.
-func promptUserBooks(w io.Writer, r *bufio.Reader, books *[]string) error {
+func promptUserBooks(w io.Writer, read func() (string, error), books *[]string) error {
 	fmt.Fprint(w, "Enter a name of book: ")
 
-	input, err := r.ReadString('\n')
+	input, err := read()
 	if err != nil {
-		return fmt.Errorf("couldn't read book name: %v", err)
+		return fmt.Errorf("couldn't read book name: %w", err)
 	}
 	input = strings.TrimSpace(input)
 
@@ -219,40 +545,171 @@ func promptUserBooks(w io.Writer, r *bufio.Reader, books *[]string) error {
 	}
 
 	*books = append(*books, input)
-	return promptUserBooks(w, r, books)
+	return promptUserBooks(w, read, books)
 }
 
-// rmUser searches for a user by name, and if it finds them, removes them from the slice
-// of users; after that, the snapshot of the slice of users is saved in the storage.
-func rmUser(w io.Writer, r io.Reader, strg *storage.Storage, users *[]user.User) (err error) {
-	reader := bufio.NewReader(r)
+// askEdit prompts for the new user's data, with the current values as the defaults.
+func (s *session) askEdit(args values) error {
+	if strings.TrimSpace(args.get("name")) == "" {
+		fmt.Fprint(s.w, "Enter the name of user you want to edit: ")
+		input, err := s.readLine()
+		if err != nil {
+			return fmt.Errorf("couldn't read name: %w", err)
+		}
+		args.set("name", strings.TrimSpace(input))
+	}
+	users := s.db().Users()
+	i, ok := user.Slice(users).FindName(strings.TrimSpace(args.get("name")))
+	if !ok {
+		return nil // edit reports it
+	}
+	u := users[i]
 
-	// Find the user by name. Determine the user's index.
-	fmt.Fprint(w, "Enter the name of user you want to remove: ")
-	input, err := reader.ReadString('\n')
+	name, err := promptDefault(s.w, s.readLine, "Enter name", u.Name)
+	if err != nil {
+		return err
+	}
+	age, err := promptEditAge(s.w, s.readLine, u.Age)
 	if err != nil {
-		return fmt.Errorf("couldn't read name: %v", err)
+		return err
+	}
+	active, err := promptEditActiveStatus(s.w, s.readLine, u.Active)
+	if err != nil {
+		return err
+	}
+	mass, err := promptEditMass(s.w, s.readLine, u.Mass)
+	if err != nil {
+		return err
+	}
+	books, err := promptEditBooks(s.w, s.readLine, u.Books)
+	if err != nil {
+		return err
 	}
-	name := strings.TrimSpace(input)
 
-	i, ok := user.Slice(*users).FindName(name)
-	if !ok {
-		return ErrUserNotFound
+	args.set("new_name", name)
+	args.set("age", strconv.Itoa(int(age)))
+	args.set("active", strconv.FormatBool(active))
+	args.set("mass", strconv.FormatFloat(mass, 'g', -1, 64))
+	args.set("books", books...)
+	return nil
+}
+
+// promptDefault prompts for a value. The empty input keeps the current value.
+func promptDefault(w io.Writer, read func() (string, error), prompt, current string) (string, error) {
+	fmt.Fprintf(w, "%s [%s]: ", prompt, current)
+
+	input, err := read()
+	if err != nil {
+		return "", fmt.Errorf("couldn't read %s: %w", strings.ToLower(strings.TrimPrefix(prompt, "Enter ")), err)
 	}
+	input = strings.TrimSpace(input)
 
-	// Remove the user from the users slice.
-	(*users)[i] = (*users)[len(*users)-1]
-	*users = (*users)[:len(*users)-1]
+	if input == "" {
+		return current, nil
+	}
+	return input, nil
+}
 
-	// Save the snapshot.
-	if err = strg.SaveSnapshot(users); err != nil {
-		return err
+// promptEditAge prompts for a new age of the user until it is valid.
+func promptEditAge(w io.Writer, read func() (string, error), current uint8) (uint8, error) {
+	input, err := promptDefault(w, read, "Enter age", strconv.Itoa(int(current)))
+	if err != nil {
+		return 0, err
 	}
 
-	return nil
+	age, err := user.ParseAge(input)
+	if err != nil {
+		fmt.Fprintln(w, "Please, provide with a number from 0 to 255.")
+		return promptEditAge(w, read, current)
+	}
+
+	return age, nil
+}
+
+// promptEditActiveStatus prompts if the user is active now.
+func promptEditActiveStatus(w io.Writer, read func() (string, error), current bool) (bool, error) {
+	answer := "no"
+	if current {
+		answer = "yes"
+	}
+	input, err := promptDefault(w, read, "Is the user is active now? [yes/no]", answer)
+	if err != nil {
+		return false, err
+	}
+
+	if active, err := user.ParseYesNo(input); err == nil {
+		return active, nil
+	}
+	fmt.Fprintln(w, "Please, provide with [yes/no], [YyNn].")
+	return promptEditActiveStatus(w, read, current)
+}
+
+// promptEditMass prompts for a new mass of the user until it is valid.
+func promptEditMass(w io.Writer, read func() (string, error), current float64) (float64, error) {
+	input, err := promptDefault(w, read, "Enter the user's mass", strconv.FormatFloat(current, 'f', -1, 64))
+	if err != nil {
+		return 0, err
+	}
+	if input == strconv.FormatFloat(current, 'f', -1, 64) {
+		return current, nil
+	}
+
+	mass, err := user.ParseMass(input)
+	if err != nil {
+		fmt.Fprintln(w, "Please, provide with a number.")
+		return promptEditMass(w, read, current)
+	}
+
+	return mass, nil
 }
 
-func show(w io.Writer, users []user.User) {
-	table.PrintData(w, user.Slice(users), user.Headers)
-	fmt.Fprintln(w, "Number of active users:", user.Slice(users).NumOfActiveUsers())
+// promptEditBooks prompts for the books to add to the list or to remove from it.
+func promptEditBooks(w io.Writer, read func() (string, error), current []string) ([]string, error) {
+	books := slices.Clone(current)
+	for {
+		if len(books) == 0 {
+			fmt.Fprintln(w, "Books: none")
+		} else {
+			fmt.Fprintln(w, "Books:", strings.ReplaceAll(user.Books(books).String(), "\n", ", "))
+		}
+		fmt.Fprint(w, "Enter +book to add, -book to remove, or nothing to finish: ")
+
+		input, err := read()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read book name: %w", err)
+		}
+		input = strings.TrimSpace(input)
+
+		if input == "" {
+			return books, nil //-> exit point.
+		}
+		op, book := input[0], strings.TrimSpace(input[1:])
+		switch {
+		case book == "" || op != '+' && op != '-':
+			fmt.Fprintln(w, "Please, start with + or - and give the name of book.")
+		case op == '+' && !slices.Contains(books, book):
+			books = append(books, book)
+		case op == '-':
+			i := slices.Index(books, book)
+			if i < 0 {
+				fmt.Fprintf(w, "No such book: %q.\n", book)
+				continue
+			}
+			books = slices.Delete(books, i, i+1)
+		}
+	}
+}
+
+// askName prompts for the name of the user to remove.
+func (s *session) askName(args values) error {
+	if strings.TrimSpace(args.get("name")) != "" {
+		return nil
+	}
+	fmt.Fprint(s.w, "Enter the name of user you want to remove: ")
+	input, err := s.readLine()
+	if err != nil {
+		return fmt.Errorf("couldn't read name: %w", err)
+	}
+	args.set("name", strings.TrimSpace(input))
+	return nil
 }
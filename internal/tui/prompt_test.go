@@ -0,0 +1,333 @@
+package tui
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"practice/internal/auth"
+	"practice/internal/storage"
+	"practice/internal/user"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunScript(t *testing.T) {
+	ann := user.User{ID: 1, Name: "Ann", Age: 30}
+	jane := user.User{ID: 2, Name: "Jane Doe", Age: 31, Active: true, Mass: 62.5, Books: []string{"Dune", "Emma"}}
+
+	tests := []struct {
+		name     string
+		script   string
+		opts     []Option
+		readOnly bool
+		wantErr  error
+		want     []user.User
+		out      string // a substring of the output
+	}{
+		{
+			name: "add",
+			script: "# a comment\n\n" +
+				`add name="Jane Doe" age=31 active=yes mass=62.5 books="Dune;Emma"` + "\n",
+			want: []user.User{ann, jane},
+			out:  "User added",
+		},
+		{
+			name:   "edit and remove",
+			script: "add name=Jane Doe\nedit Jane Doe age=31 active=yes mass=62.5 books=Dune add_books=Emma\nremove Ann\n",
+			want:   []user.User{jane},
+			out:    "User deleted",
+		},
+		{
+			name:   "quoted name",
+			script: `edit "Ann" new_name="Ann Lee" books=` + "\n",
+			want:   []user.User{{ID: 1, Name: "Ann Lee", Age: 30}},
+		},
+		{
+			name:    "rename to taken name",
+			script:  "add name=Bob\nbegin\nedit Bob new_name=Ann\n",
+			wantErr: ErrScriptFailed,
+			want:    []user.User{ann, {ID: 2, Name: "Bob"}},
+			out:     `Line 3: user already exists: "Ann"`,
+		},
+		{
+			name:   "mass",
+			script: "add name=Bob mass=0.5\nadd name=Carl mass=-1\n",
+			want:   []user.User{ann, {ID: 2, Name: "Bob", Mass: 50}, {ID: 3, Name: "Carl"}},
+		},
+		{
+			name:    "stop",
+			script:  "add name=Bob age=old\nadd name=Carl\n",
+			wantErr: ErrScriptFailed,
+			want:    []user.User{ann},
+			out:     `Line 1: invalid age "old"`,
+		},
+		{
+			name:    "continue",
+			script:  "add name=Bob age=old\nbogus\nadd name=Carl\n",
+			opts:    []Option{WithContinueOnError()},
+			wantErr: ErrScriptFailed,
+			want:    []user.User{ann, {ID: 2, Name: "Carl"}},
+			out:     `Line 2: Unknown operator "bogus"`,
+		},
+		{
+			name:    "no prompts",
+			script:  "add\nBob\nremove\n",
+			wantErr: ErrScriptFailed,
+			want:    []user.User{ann},
+			out:     "Line 1: no name is entered",
+		},
+		{
+			name:    "unknown option",
+			script:  "add name=Bob height=180\n",
+			wantErr: ErrScriptFailed,
+			want:    []user.User{ann},
+			out:     `Unknown option "height"`,
+		},
+		{
+			name:     "read only",
+			script:   "show\nremove Ann\n",
+			readOnly: true,
+			wantErr:  ErrScriptFailed,
+			want:     []user.User{ann},
+			out:      `Line 2: Permission denied`,
+		},
+		{
+			name:   "undo remove",
+			script: "remove Ann\nundo\n",
+			want:   []user.User{ann},
+			out:    "Undone: remove Ann",
+		},
+		{
+			name:   "undo and redo",
+			script: "edit Ann age=40\nadd name=Bob\nundo\nundo\nredo\n",
+			want:   []user.User{{ID: 1, Name: "Ann", Age: 40}},
+			out:    "Undone: add Bob\nUndone: edit Ann\nRedone: edit Ann",
+		},
+		{
+			name:    "nothing to undo",
+			script:  "undo\n",
+			wantErr: ErrScriptFailed,
+			want:    []user.User{ann},
+			out:     "Line 1: Nothing to undo",
+		},
+		{
+			name:    "redo after change",
+			script:  "add name=Bob\nundo\nadd name=Carl\nredo\n",
+			wantErr: ErrScriptFailed,
+			want:    []user.User{ann, {ID: 3, Name: "Carl"}}, // the ID of Bob isn't given again
+			out:     "Line 4: Nothing to redo",
+		},
+		{
+			name:   "transaction",
+			script: "begin\nadd name=Bob\nedit Ann age=40\nremove Bob\nadd name=Carl\ncommit\n",
+			want:   []user.User{{ID: 1, Name: "Ann", Age: 40}, {ID: 2, Name: "Carl"}},
+			out:    "Committed 2 changes",
+		},
+		{
+			name:   "transaction adds",
+			script: "begin\nadd name=Bob\nadd name=Carl\nadd name=Dan\ncommit\n",
+			want:   []user.User{ann, {ID: 2, Name: "Bob"}, {ID: 3, Name: "Carl"}, {ID: 4, Name: "Dan"}},
+			out:    "Committed 3 changes",
+		},
+		{
+			name:   "rollback",
+			script: "begin\nremove Ann\nshow\nrollback\n",
+			want:   []user.User{ann},
+			out:    "Rolled back 1 change",
+		},
+		{
+			name:    "failed transaction",
+			script:  "begin\nremove Ann\nadd name=Bob age=old\ncommit\n",
+			wantErr: ErrScriptFailed,
+			want:    []user.User{ann},
+		},
+		{
+			name:   "undo commit",
+			script: "begin\nremove Ann\nadd name=Bob\ncommit\nundo\n",
+			want:   []user.User{ann},
+			out:    "Undone: commit of 2 users",
+		},
+		{
+			name:    "no transaction",
+			script:  "commit\n",
+			wantErr: ErrScriptFailed,
+			want:    []user.User{ann},
+			out:     "Line 1: No transaction is begun",
+		},
+		{
+			name:   "quit",
+			script: "quit\nadd name=Bob\n",
+			want:   []user.User{ann},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strg := storage.New(storage.NewMemory(ann))
+			if err := strg.Load(); err != nil {
+				t.Fatal(err)
+			}
+			defer strg.Close()
+			role := auth.RoleWrite
+			if tt.readOnly {
+				role = auth.RoleRead
+			}
+
+			var out strings.Builder
+			err := RunScript(&out, strings.NewReader(tt.script), strg, role, tt.opts...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("RunScript() error = %v, want %v", err, tt.wantErr)
+			}
+			if !strings.Contains(out.String(), tt.out) {
+				t.Errorf("output doesn't contain %q:\n%s", tt.out, out.String())
+			}
+			if got := strg.Users(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("users = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptEditActiveStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		current bool
+		input   []string
+		want    bool
+	}{
+		{"keep inactive", false, []string{""}, false},
+		{"keep active", true, []string{""}, true},
+		{"change", false, []string{"y"}, true},
+		{"ask again", true, []string{"maybe", "no"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := tt.input
+			read := func() (string, error) {
+				if len(input) == 0 {
+					return "", io.EOF
+				}
+				line := input[0]
+				input = input[1:]
+				return line, nil
+			}
+			got, err := promptEditActiveStatus(io.Discard, read, tt.current)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("promptEditActiveStatus() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunScript_Backups(t *testing.T) {
+	john := user.User{ID: 1, Name: "John Doe"}
+	jake := user.User{ID: 2, Name: "Jake Doe"}
+	jane := user.User{ID: 3, Name: "Jane Doe"}
+
+	tests := []struct {
+		name     string
+		script   string // {new} and {old} stand for the IDs of the backups
+		readOnly bool
+		wantErr  error
+		want     []user.User
+		out      []string // the substrings of the output
+	}{
+		{
+			name:   "list",
+			script: "backups\n",
+			want:   []user.User{john, jake, jane},
+			out:    []string{"{new}", "{old}"},
+		},
+		{
+			name:    "restore",
+			script:  "remove John Doe\nrestore {old}\nundo\n",
+			wantErr: ErrScriptFailed,
+			want:    []user.User{john},
+			out:     []string{"Backup restored", "Line 3: Nothing to undo"},
+		},
+		{
+			name:    "unknown backup",
+			script:  "restore 20000101-000000.000000\n",
+			wantErr: ErrScriptFailed,
+			want:    []user.User{john, jake, jane},
+			out:     []string{"Line 1: backup is not found"},
+		},
+		{
+			name:    "no ID",
+			script:  "restore\n",
+			wantErr: ErrScriptFailed,
+			want:    []user.User{john, jake, jane},
+			out:     []string{`Usage: restore <id>. Enter "backups" to see the IDs.`},
+		},
+		{
+			name:     "read only",
+			script:   "backups\nrestore {old}\n",
+			readOnly: true,
+			wantErr:  ErrScriptFailed,
+			want:     []user.User{john, jake, jane},
+			out:      []string{"{old}", "Line 2: Permission denied"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strg, err := storage.NewStorage(storage.WithPath(filepath.Join(t.TempDir(), "test.database")), storage.WithBackups(2))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer strg.Close()
+			if err = strg.Load(); err != nil {
+				t.Fatal(err)
+			}
+			// Saving the snapshot backs up the previous one.
+			for _, u := range []user.User{john, jake, jane} {
+				if _, err = strg.Add(u); err != nil {
+					t.Fatal(err)
+				}
+				if err = strg.SaveSnapshot(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			backups, err := strg.Backups()
+			if err != nil || len(backups) != 2 {
+				t.Fatalf("Backups() = %v, %v, want 2 backups", backups, err)
+			}
+			ids := strings.NewReplacer("{new}", backups[0].ID, "{old}", backups[1].ID)
+			role := auth.RoleWrite
+			if tt.readOnly {
+				role = auth.RoleRead
+			}
+
+			var out strings.Builder
+			err = RunScript(&out, strings.NewReader(ids.Replace(tt.script)), strg, role)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("RunScript() error = %v, want %v", err, tt.wantErr)
+			}
+			for _, s := range tt.out {
+				if s = ids.Replace(s); !strings.Contains(out.String(), s) {
+					t.Errorf("output doesn't contain %q:\n%s", s, out.String())
+				}
+			}
+			if got := strg.Users(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("users = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("no backups", func(t *testing.T) {
+		strg := storage.New(storage.NewMemory(john))
+		if err := strg.Load(); err != nil {
+			t.Fatal(err)
+		}
+		defer strg.Close()
+		var out strings.Builder
+		if err := RunScript(&out, strings.NewReader("backups\n"), strg, auth.RoleWrite); !errors.Is(err, ErrScriptFailed) {
+			t.Errorf("RunScript() error = %v, want %v", err, ErrScriptFailed)
+		}
+		if want := "storage doesn't keep backups"; !strings.Contains(out.String(), want) {
+			t.Errorf("output doesn't contain %q:\n%s", want, out.String())
+		}
+	})
+}
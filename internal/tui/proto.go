@@ -0,0 +1,197 @@
+package tui
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"practice/internal/auth"
+	"strings"
+)
+
+// Request is a request of the JSON protocol: a JSON object on a line, like
+//
+//	{"cmd": "add", "args": {"name": "Ann", "age": 30, "books": ["Dune"]}}
+//
+// The commands and their arguments are the same as in the prompt; the arguments
+// prompted for in the prompt are given by name. Before logging in, the session
+// accepts only "login" with the arguments "name" and "password", and "quit".
+type Request struct {
+	Cmd  string         `json:"cmd"`
+	Args map[string]any `json:"args,omitempty"`
+}
+
+// Response is a response of the JSON protocol: a JSON object on a line.
+type Response struct {
+	Status string `json:"status"` // StatusOK or StatusError
+	Data   any    `json:"data"`
+	Error  *Error `json:"error"`
+}
+
+// The statuses of the responses.
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// Handshake is the data of the response to "proto json".
+type Handshake struct {
+	Proto string `json:"proto"`
+	// Login tells that the session needs the "login" request.
+	Login bool `json:"login"`
+}
+
+// Login is the data of the response to "login".
+type Login struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// serveJSON serves the requests of the JSON protocol until the session ends.
+// It starts with the response to "proto json" on a new line.
+func (s *session) serveJSON() error {
+	fmt.Fprintln(s.w)
+	enc := json.NewEncoder(s.w)
+	if err := enc.Encode(s.handshake()); err != nil {
+		return err
+	}
+
+	failed := 0
+	for {
+		s.limits.Wait()
+		line, err := s.readLine()
+		if err == io.EOF {
+			return ErrEndOfSession
+		}
+		if err == ErrLineTooLong {
+			enc.Encode(errorResponse(badArgs("the request is longer than %d bytes", s.maxLine)))
+		}
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		res, end := s.handleJSON([]byte(line), &failed)
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+		if end != nil {
+			return end
+		}
+	}
+}
+
+// handleJSON returns the response to the request line. It returns an error
+// if the session ends after the response.
+func (s *session) handleJSON(line []byte, failed *int) (Response, error) {
+	var req Request
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(&req); err != nil {
+		return errorResponse(badArgs("invalid request: %v", err)), nil
+	}
+	args, err := parseArgs(req.Args)
+	if err != nil {
+		return errorResponse(err), nil
+	}
+
+	if err := s.limits.Command(req.Cmd); err != nil {
+		return errorResponse(err), nil
+	}
+	if strings.EqualFold(req.Cmd, "login") {
+		return s.loginJSON(args, failed)
+	}
+	cmd, ok := lookup(req.Cmd)
+	switch {
+	case !ok:
+		return errorResponse(&Error{Code: CodeUnknownCommand, Message: fmt.Sprintf("unknown command %q", req.Cmd)}), nil
+	case !s.loggedIn && cmd.name != "quit":
+		return errorResponse(&Error{Code: CodeUnauthorized, Message: "login required"}), nil
+	case cmd.write && s.role != auth.RoleWrite:
+		return errorResponse(&Error{Code: CodeForbidden, Message: fmt.Sprintf("permission denied: %q needs the write access", cmd.name)}), nil
+	case cmd.local && s.remote:
+		return errorResponse(&Error{Code: CodeForbidden, Message: fmt.Sprintf("%q is available in the local prompt only", cmd.name)}), nil
+	}
+
+	data, err := cmd.exec(s, args)
+	switch {
+	case err == ErrEndOfSession:
+		return Response{Status: StatusOK}, err
+	case err == errProtoJSON:
+		return Response{Status: StatusOK, Data: s.handshake().Data}, nil
+	case err != nil:
+		return errorResponse(err), nil
+	}
+	return Response{Status: StatusOK, Data: data}, nil
+}
+
+// loginJSON serves the "login" request. After maxLoginAttempts failed attempts,
+// the session ends with ErrLoginFailed.
+func (s *session) loginJSON(args values, failed *int) (Response, error) {
+	if s.users == nil {
+		return Response{Status: StatusOK, Data: Login{Name: args.get("name"), Role: s.role.String()}}, nil
+	}
+	name := strings.TrimSpace(args.get("name"))
+	role, err := s.users.Authenticate(name, args.get("password"))
+	if err != nil {
+		*failed++
+		res := errorResponse(&Error{Code: CodeUnauthorized, Message: err.Error()})
+		if *failed >= maxLoginAttempts {
+			return res, ErrLoginFailed
+		}
+		return res, nil
+	}
+	s.loginAs(name, role)
+	return Response{Status: StatusOK, Data: Login{Name: name, Role: role.String()}}, nil
+}
+
+func (s *session) handshake() Response {
+	return Response{Status: StatusOK, Data: Handshake{Proto: "json", Login: !s.loggedIn}}
+}
+
+func errorResponse(err error) Response {
+	return Response{Status: StatusError, Error: toError(err)}
+}
+
+// parseArgs converts the arguments of the request to values. An argument
+// is a string, a number, a boolean, or an array of them.
+func parseArgs(in map[string]any) (values, error) {
+	args := make(values, len(in))
+	for name, v := range in {
+		if a, ok := v.([]any); ok {
+			args[name] = make([]string, 0, len(a))
+			for _, v := range a {
+				s, ok := scalar(v)
+				if !ok {
+					return nil, badArgs("invalid argument %q", name)
+				}
+				args[name] = append(args[name], s)
+			}
+			continue
+		}
+		s, ok := scalar(v)
+		if !ok {
+			return nil, badArgs("invalid argument %q", name)
+		}
+		args.set(name, s)
+	}
+	return args, nil
+}
+
+// scalar returns the string form of the string, the number or the boolean.
+func scalar(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		if v {
+			return "yes", true
+		}
+		return "no", true
+	}
+	return "", false
+}
@@ -0,0 +1,195 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"practice/internal/storage"
+	"practice/internal/user"
+	"reflect"
+)
+
+// userStore keeps the users the commands of the session read and change:
+// it is the storage, or the transaction if the session has begun one.
+type userStore interface {
+	Users() []user.User
+	Add(u user.User) (user.User, error)
+	Update(u user.User) error
+	Delete(id uint64) error
+}
+
+// db returns the users of the session.
+func (s *session) db() userStore {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.strg
+}
+
+// tx is the transaction of a session. The changes are made to a copy of the users,
+// which other sessions don't see, and commit applies them to the storage at once.
+type tx struct {
+	base  []user.User // the users at the start of the transaction
+	users []user.User // the users with the changes
+	// The added users have the provisional IDs from firstID on.
+	firstID, nextID uint64
+}
+
+// newTx begins the transaction over the base users. The provisional IDs
+// start from nextID, which must be above the IDs of the base users.
+func newTx(base []user.User, nextID uint64) *tx {
+	users := make([]user.User, len(base))
+	copy(users, base)
+	return &tx{base: base, users: users, firstID: nextID, nextID: nextID}
+}
+
+// Users returns a copy of the users of the transaction.
+func (t *tx) Users() []user.User {
+	users := make([]user.User, len(t.users))
+	copy(users, t.users)
+	return users
+}
+
+// Add adds the user with a new ID. The ID is provisional: the storage gives
+// the user another one on commit.
+func (t *tx) Add(u user.User) (user.User, error) {
+	u.ID = t.nextID
+	if err := storage.CheckName(t.users, u); err != nil {
+		return u, err
+	}
+	t.nextID++
+	t.users = append(t.users, u)
+	return u, nil
+}
+
+func (t *tx) Update(u user.User) error {
+	i := t.indexOf(u.ID)
+	if i < 0 {
+		return storage.ErrNotFound
+	}
+	if t.users[i].Name != u.Name {
+		if err := storage.CheckName(t.users, u); err != nil {
+			return err
+		}
+	}
+	t.users[i] = u
+	return nil
+}
+
+func (t *tx) Delete(id uint64) error {
+	i := t.indexOf(id)
+	if i < 0 {
+		return storage.ErrNotFound
+	}
+	t.users = append(t.users[:i], t.users[i+1:]...)
+	return nil
+}
+
+func (t *tx) indexOf(id uint64) int {
+	for i, u := range t.users {
+		if u.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// swaps returns the changes of the transaction: the removed and the changed
+// users in the order of the storage, and then the added ones without the IDs.
+func (t *tx) swaps() []storage.Swap {
+	var swaps []storage.Swap
+	for i := range t.base {
+		old := &t.base[i]
+		j := t.indexOf(old.ID)
+		switch {
+		case j < 0:
+			swaps = append(swaps, storage.Swap{Old: old})
+		case !reflect.DeepEqual(t.users[j], *old):
+			u := t.users[j]
+			swaps = append(swaps, storage.Swap{Old: old, New: &u})
+		}
+	}
+	for _, u := range t.users {
+		if u.ID >= t.firstID {
+			u := u
+			u.ID = 0
+			swaps = append(swaps, storage.Swap{New: &u})
+		}
+	}
+	return swaps
+}
+
+// txInfo is the data of the responses to begin, commit and rollback.
+type txInfo struct {
+	Changes int `json:"changes"` // the number of the users changed
+}
+
+// begin begins the transaction.
+func (s *session) begin(args values) (any, error) {
+	if s.tx != nil {
+		return nil, badArgs("The transaction is already begun. Enter \"commit\" or \"rollback\" to end it.")
+	}
+	if s.strg.ReadOnly() {
+		return nil, storage.ErrReadOnly
+	}
+	// The users are read first: the next ID of the storage is above their IDs.
+	s.tx = newTx(s.strg.Users(), s.strg.NextID())
+	return txInfo{}, nil
+}
+
+// commit applies the changes of the transaction to the storage and ends it.
+// If another session has changed the same users since the transaction began,
+// nothing is applied and the transaction is rolled back.
+func (s *session) commit(args values) (any, error) {
+	if s.tx == nil {
+		return nil, errNoTx
+	}
+	swaps := s.tx.swaps()
+	s.tx = nil
+	err := s.strg.Apply(swaps...)
+	if errors.Is(err, storage.ErrConflict) {
+		return nil, &Error{Code: CodeConflict,
+			Message: "The transaction is rolled back: another session has changed its users since it began"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(swaps) > 0 {
+		s.history.record(change{cmd: "commit", swaps: swaps})
+	}
+	return txInfo{Changes: len(swaps)}, nil
+}
+
+// rollback discards the changes of the transaction and ends it.
+func (s *session) rollback(args values) (any, error) {
+	if s.tx == nil {
+		return nil, errNoTx
+	}
+	n := len(s.tx.swaps())
+	s.tx = nil
+	return txInfo{Changes: n}, nil
+}
+
+var (
+	errNoTx = badArgs("No transaction is begun. Enter \"begin\" to begin one.")
+	errInTx = badArgs("The command can't be used in the transaction. Enter \"commit\" or \"rollback\" to end it.")
+)
+
+func (s *session) printBegin(data any) {
+	fmt.Fprintln(s.w, "Transaction begun. Enter \"commit\" to apply the changes or \"rollback\" to discard them.")
+}
+
+func (s *session) printCommit(data any) {
+	fmt.Fprintf(s.w, "Committed %s\n", plural(data.(txInfo).Changes, "change"))
+}
+
+func (s *session) printRollback(data any) {
+	fmt.Fprintf(s.w, "Rolled back %s\n", plural(data.(txInfo).Changes, "change"))
+}
+
+// plural returns the number of the things, like "1 change" or "2 changes".
+func plural(n int, thing string) string {
+	if n == 1 {
+		return "1 " + thing
+	}
+	return fmt.Sprintf("%d %ss", n, thing)
+}
@@ -0,0 +1,204 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"practice/internal/storage"
+	"sync"
+	"time"
+)
+
+// maxUndo is the number of the changes kept in the history of a session.
+const maxUndo = 100
+
+// change is a change of the users made by a command.
+type change struct {
+	cmd   string
+	name  string // the name of the user the command was given; "" for commit
+	swaps []storage.Swap
+}
+
+// inverse returns the swaps that revert the change.
+func (c change) inverse() []storage.Swap {
+	swaps := make([]storage.Swap, len(c.swaps))
+	for i, sw := range c.swaps {
+		swaps[len(swaps)-1-i] = storage.Swap{Old: sw.New, New: sw.Old}
+	}
+	return swaps
+}
+
+// history is the undo history of a session.
+type history struct {
+	undo, redo []change
+}
+
+// record adds the change made by a command. The undone changes can't be redone after it.
+func (h *history) record(c change) {
+	h.undo = push(h.undo, c)
+	h.redo = nil
+}
+
+// record adds the change made by a command to the history of the session.
+// The changes made in the transaction are recorded on commit as one change.
+func (s *session) record(c change) {
+	if s.tx == nil {
+		s.history.record(c)
+	}
+}
+
+// push adds the change to the stack, dropping the oldest one over maxUndo.
+func push(stack []change, c change) []change {
+	if len(stack) == maxUndo {
+		stack = stack[1:]
+	}
+	return append(stack, c)
+}
+
+// Histories keep the undo histories of the ended sessions for a while, so the
+// user that reconnects may undo the changes of the previous session. Only the
+// sessions that log in keep their histories: the history belongs to the user.
+// If the user has several sessions at once, the history of the last one to end is kept.
+type Histories struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu   sync.Mutex
+	kept map[string]keptHistory
+}
+
+type keptHistory struct {
+	h       *history
+	expires time.Time
+}
+
+// NewHistories returns the Histories that keep a history for the window
+// after the session ends.
+func NewHistories(window time.Duration) *Histories {
+	return &Histories{window: window, now: time.Now, kept: make(map[string]keptHistory)}
+}
+
+// take removes the history of the key and returns it; nil if there is none.
+func (hs *Histories) take(key string) *history {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	k, ok := hs.kept[key]
+	delete(hs.kept, key)
+	if !ok || !hs.now().Before(k.expires) {
+		return nil
+	}
+	return k.h
+}
+
+// keep keeps the history of the key, and forgets the expired ones.
+func (hs *Histories) keep(key string, h *history) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	now := hs.now()
+	for key, k := range hs.kept {
+		if !now.Before(k.expires) {
+			delete(hs.kept, key)
+		}
+	}
+	if len(h.undo) > 0 || len(h.redo) > 0 {
+		hs.kept[key] = keptHistory{h: h, expires: now.Add(hs.window)}
+	}
+}
+
+// takeHistory continues the history kept from the previous session of the user.
+// The session without a user has its own history.
+func (s *session) takeHistory() {
+	if s.histories == nil || s.name == "" {
+		return
+	}
+	if h := s.histories.take(s.name); h != nil {
+		s.history = h
+	}
+}
+
+// keepHistory keeps the history of the ended session for the next one of the user.
+func (s *session) keepHistory() {
+	if s.histories != nil && s.name != "" {
+		s.histories.keep(s.name, s.history)
+	}
+}
+
+// changeInfo is the data of the responses to undo and redo.
+type changeInfo struct {
+	Cmd   string `json:"cmd"`
+	Name  string `json:"name,omitempty"`
+	Users int    `json:"users"` // the number of the users changed
+}
+
+func (c change) info() changeInfo {
+	return changeInfo{Cmd: c.cmd, Name: c.name, Users: len(c.swaps)}
+}
+
+// undo reverts the last change of the session. If another session has changed
+// the users since, the change can't be undone any more, so it is dropped.
+func (s *session) undo(args values) (any, error) {
+	h := s.history
+	if s.tx != nil {
+		return nil, errInTx
+	}
+	if len(h.undo) == 0 {
+		return nil, badArgs("Nothing to undo")
+	}
+	c := h.undo[len(h.undo)-1]
+	err := s.strg.Apply(c.inverse()...)
+	if errors.Is(err, storage.ErrConflict) {
+		h.undo = h.undo[:len(h.undo)-1]
+		return nil, conflict("undo", c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	h.undo = h.undo[:len(h.undo)-1]
+	h.redo = push(h.redo, c)
+	return c.info(), nil
+}
+
+// redo makes the last undone change again. Like for undo, the change is dropped
+// if the users have been changed since.
+func (s *session) redo(args values) (any, error) {
+	h := s.history
+	if s.tx != nil {
+		return nil, errInTx
+	}
+	if len(h.redo) == 0 {
+		return nil, badArgs("Nothing to redo")
+	}
+	c := h.redo[len(h.redo)-1]
+	err := s.strg.Apply(c.swaps...)
+	if errors.Is(err, storage.ErrConflict) {
+		h.redo = h.redo[:len(h.redo)-1]
+		return nil, conflict("redo", c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	h.redo = h.redo[:len(h.redo)-1]
+	h.undo = push(h.undo, c)
+	return c.info(), nil
+}
+
+// conflict returns the error of the change that can't be undone or redone.
+func conflict(op string, c change) *Error {
+	return &Error{Code: CodeConflict, Message: fmt.Sprintf(
+		"Can't %s %s: another session has changed the same users since, so the change is dropped from the history", op, describe(c.info()))}
+}
+
+// describe returns the description of the change, like "remove Ann" or "commit of 3 users".
+func describe(c changeInfo) string {
+	if c.Name != "" {
+		return c.Cmd + " " + c.Name
+	}
+	return fmt.Sprintf("%s of %s", c.Cmd, plural(c.Users, "user"))
+}
+
+func (s *session) printUndo(data any) {
+	fmt.Fprintln(s.w, "Undone:", describe(data.(changeInfo)))
+}
+
+func (s *session) printRedo(data any) {
+	fmt.Fprintln(s.w, "Redone:", describe(data.(changeInfo)))
+}
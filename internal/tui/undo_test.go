@@ -0,0 +1,48 @@
+package tui
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistories(t *testing.T) {
+	now := time.Unix(0, 0)
+	hs := NewHistories(time.Minute)
+	hs.now = func() time.Time { return now }
+	h := &history{}
+	h.record(change{cmd: "remove"})
+
+	hs.keep("ann", h)
+	hs.keep("bob", &history{}) // nothing to keep
+	if got := hs.take("bob"); got != nil {
+		t.Errorf("take(bob) = %v, want nil", got)
+	}
+	now = now.Add(59 * time.Second)
+	if got := hs.take("ann"); got != h {
+		t.Errorf("take(ann) = %v, want %v", got, h)
+	}
+	if got := hs.take("ann"); got != nil {
+		t.Errorf("take(ann) again = %v, want nil", got)
+	}
+
+	hs.keep("ann", h)
+	now = now.Add(time.Minute)
+	if got := hs.take("ann"); got != nil {
+		t.Errorf("take(ann) after the window = %v, want nil", got)
+	}
+}
+
+func TestHistory_Max(t *testing.T) {
+	h := &history{}
+	for i := 0; i < maxUndo+10; i++ {
+		h.record(change{cmd: "add"})
+	}
+	h.redo = []change{{cmd: "add"}}
+	h.record(change{cmd: "remove"})
+	if len(h.undo) != maxUndo || h.undo[maxUndo-1].cmd != "remove" {
+		t.Errorf("len(undo) = %d, want %d with remove last", len(h.undo), maxUndo)
+	}
+	if h.redo != nil {
+		t.Errorf("redo = %v, want nil", h.redo)
+	}
+}
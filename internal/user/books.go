@@ -7,9 +7,12 @@ import (
 	"golang.org/x/exp/slices"
 )
 
+// BookHeaders are the headers of the table of AvgAgePerBookSlice.
+var BookHeaders = []string{"Book", "Avg age"}
+
 type AvgAgePerBook struct {
-	BookTitle string
-	AvgAge    int
+	BookTitle string `json:"book"`
+	AvgAge    int    `json:"avg_age"`
 }
 
 func AvgAgeOfReadersPerBook(users []User) (apb AvgAgePerBookSlice) {
@@ -1,29 +1,200 @@
 // This package contains the encoding/decoding methods for a slice of User type.
 // Schema:
 //
-//		 Name               uint8(length) + [length]byte
-//		 ActiveIndex | Age  uint64: 63-bit bool (active field) | 62-0 bits uint (age field)
-//		 Mass               float64
-//		 Books              uint8(all books length) + [length]byte
-//	                     all books come as a single comma-separated string
+//	Header             [8]byte magic + uint16 version + uint16 schema flags
+//	Next ID            uint64: the ID for the next new user (FlagNextID)
+//	Records...         one record per user, until the end of the data
+//
+// Record (FlagChecksum):
+//
+//	Length             uint32: length of the payload
+//	Payload            [length]byte: the user fields (see below)
+//	Checksum           uint32: CRC-32 (IEEE) of the payload
+//
+// Payload:
+//
+//	ID                 uint64 (FlagUserID)
+//	Name               String
+//	Active | Age       uint64: 63-bit bool (active field) | 62-0 bits uint (age field)
+//	Mass               float64
+//	Books              uvarint(number of books) + String for each book (FlagVarint)
+//
+// String is uvarint(length) + [length]byte (FlagVarint).
+//
+// Without FlagVarint, strings are prefixed with uint8(length), and all books come
+// as a single comma-separated string.
+//
+// Data written before the header was introduced (version 0) consists of bare payloads.
+// Such data is still decoded; it is written in the current version on the next Encode.
+// The users of the data without IDs get the IDs in the order of their records, starting from 1.
+// Without FlagNextID, the next ID is the one after the greatest ID of the users.
 package user
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"math"
 	"strings"
 )
 
 const (
-	ActiveMask    uint64 = 1 << 63
-	AgeMask       uint64 = math.MaxUint64 ^ ActiveMask
-	MaxNumOfUsers        = 8
-	kgPerOz              = 0.0283495
-	kgPerQq              = 100.0
+	ActiveMask uint64 = 1 << 63
+	AgeMask    uint64 = math.MaxUint64 ^ ActiveMask
+	kgPerOz           = 0.0283495
+	kgPerQq           = 100.0
 )
 
-func Encode(w io.Writer, users []User) (err error) {
+const (
+	// LegacyVersion is the version of the headerless data.
+	LegacyVersion uint16 = 0
+	// FormatVersion is the version of the format written by Encode.
+	FormatVersion uint16 = 1
+
+	// FlagChecksum means that every record is framed by its length and CRC-32 checksum.
+	FlagChecksum uint16 = 1 << 0
+	// FlagUserID means that every record starts with the ID of the user.
+	FlagUserID uint16 = 1 << 1
+	// FlagVarint means that the strings are prefixed with uvarint lengths,
+	// and the books are encoded as a list of strings.
+	FlagVarint uint16 = 1 << 2
+	// FlagNextID means that the header is followed by the ID for the next new user.
+	// The IDs below it have been given, so they aren't reused after their users are deleted.
+	FlagNextID uint16 = 1 << 3
+
+	// SchemaFlags is the set of the schema flags of the records written by Encode.
+	SchemaFlags = FlagChecksum | FlagUserID | FlagVarint
+	// knownFlags is the set of the schema flags Decode understands.
+	knownFlags = FlagChecksum | FlagUserID | FlagVarint | FlagNextID
+)
+
+const (
+	// Size of the record frame: length and checksum.
+	frameHeadSize = 4
+	frameTailSize = 4
+	// MaxRecordSize limits the length of a record payload.
+	MaxRecordSize = 1 << 20
+)
+
+// Magic is the sequence of bytes the encoded data starts with.
+var Magic = [8]byte{0x89, 'U', 'S', 'R', '\r', '\n', 0x1a, '\n'}
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported format version")
+	ErrUnknownFlags       = errors.New("unknown schema flags")
+	ErrRecordTooLarge     = fmt.Errorf("user record exceeds %d bytes", MaxRecordSize)
+	ErrInvalidLength      = errors.New("invalid length prefix")
+)
+
+// Header describes the format of the encoded data.
+type Header struct {
+	Version uint16
+	Flags   uint16
+}
+
+// CurrentHeader is the header written by Encode.
+var CurrentHeader = Header{Version: FormatVersion, Flags: SchemaFlags}
+
+// headerSize is the size of the magic bytes and the header.
+var headerSize = len(Magic) + binary.Size(CurrentHeader)
+
+// nextIDSize is the size of the next ID following the header (FlagNextID).
+const nextIDSize = 8 // uint64
+
+// IsCurrent reports whether the data with this header is in the current format.
+// The next ID doesn't change the format of the records.
+func (h Header) IsCurrent() bool {
+	return h.Version == FormatVersion && h.Flags&^FlagNextID == SchemaFlags
+}
+
+// Damage describes a region of the encoded data that couldn't be decoded.
+type Damage struct {
+	Offset int64 // from the start of the data
+	Length int64
+}
+
+// CorruptionError is returned by Decode when some of the records are damaged.
+// The damaged records are skipped, the rest of them are returned along with the error.
+type CorruptionError struct {
+	Damaged []Damage
+}
+
+func (e *CorruptionError) Error() string {
+	var regions []string
+	for _, d := range e.Damaged {
+		regions = append(regions, fmt.Sprintf("%d-%d", d.Offset, d.Offset+d.Length))
+	}
+	return fmt.Sprintf("corrupted data at offsets %s", strings.Join(regions, ", "))
+}
+
+// add appends the damaged region to the error, merging it with the previous one if they adjoin.
+func (e *CorruptionError) add(offset, length int64) {
+	if n := len(e.Damaged); n > 0 && e.Damaged[n-1].Offset+e.Damaged[n-1].Length == offset {
+		e.Damaged[n-1].Length += length
+		return
+	}
+	e.Damaged = append(e.Damaged, Damage{Offset: offset, Length: length})
+}
+
+// WriteHeader writes the magic bytes followed by the header h.
+func WriteHeader(w io.Writer, h Header) (err error) {
+	if err = binary.Write(w, binary.BigEndian, Magic); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, h)
+}
+
+// ReadHeader reads the header from r. If the data doesn't start with the magic bytes,
+// it is considered to be legacy data: nothing is consumed and the header
+// with the LegacyVersion is returned.
+func ReadHeader(r *bufio.Reader) (h Header, err error) {
+	magic, err := r.Peek(len(Magic))
+	if err != nil && err != io.EOF {
+		return h, err
+	}
+	if !bytes.Equal(magic, Magic[:]) {
+		return Header{Version: LegacyVersion}, nil
+	}
+	if _, err = r.Discard(len(Magic)); err != nil {
+		return h, err
+	}
+	if err = binary.Read(r, binary.BigEndian, &h); err != nil {
+		return h, fmt.Errorf("couldn't read header: %w", err)
+	}
+	return h, h.Validate()
+}
+
+// Validate checks if the data with this header can be decoded.
+func (h Header) Validate() error {
+	switch {
+	case h.Version == LegacyVersion || h.Version > FormatVersion:
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, h.Version)
+	case h.Flags&^knownFlags != 0:
+		return fmt.Errorf("%w: %#04x", ErrUnknownFlags, h.Flags&^knownFlags)
+	}
+	return nil
+}
+
+// Encode writes the header and all the users to w. The next ID is the one
+// after the greatest ID of the users.
+func Encode(w io.Writer, users []User) error {
+	return EncodeNextID(w, users, Slice(users).NextID())
+}
+
+// EncodeNextID is like Encode, but writes nextID as the ID for the next new user.
+func EncodeNextID(w io.Writer, users []User, nextID uint64) (err error) {
+	h := CurrentHeader
+	h.Flags |= FlagNextID
+	if err = WriteHeader(w, h); err != nil {
+		return err
+	}
+	if err = binary.Write(w, binary.BigEndian, nextID); err != nil {
+		return err
+	}
 	for _, u := range users {
 		if err = EncodeUser(w, u); err != nil {
 			return err
@@ -32,19 +203,56 @@ func Encode(w io.Writer, users []User) (err error) {
 	return nil
 }
 
+// EncodeUser writes a single record of the user u in the current format.
+// The whole record is passed to w in one Write call. If the user doesn't fit
+// into a record, ErrRecordTooLarge is returned and nothing is written.
 func EncodeUser(w io.Writer, u User) (err error) {
-	// Encoding of the Name field.
-	nameLen := uint8(len(u.Name))
-	if err = binary.Write(w, binary.BigEndian, nameLen); err != nil {
+	payload, err := MarshalUser(u)
+	if err != nil {
 		return err
 	}
-	if err = binary.Write(w, binary.BigEndian, []byte(u.Name)); err != nil {
+	_, err = w.Write(AppendFrame(nil, payload))
+	return err
+}
+
+// MarshalUser returns the record payload of the user u in the current format.
+func MarshalUser(u User) ([]byte, error) {
+	var payload bytes.Buffer
+	if err := encodePayload(&payload, u); err != nil {
+		return nil, err
+	}
+	if payload.Len() > MaxRecordSize {
+		return nil, fmt.Errorf("%w: %q", ErrRecordTooLarge, u.Name)
+	}
+	return payload.Bytes(), nil
+}
+
+// UnmarshalUser decodes the record payload written with the schema flags.
+func UnmarshalUser(payload []byte, flags uint16) (User, error) {
+	return decodePayload(bytes.NewReader(payload), flags)
+}
+
+// AppendFrame appends to dst the payload framed by its length and checksum.
+func AppendFrame(dst, payload []byte) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(payload)))
+	dst = append(dst, payload...)
+	return binary.BigEndian.AppendUint32(dst, crc32.ChecksumIEEE(payload))
+}
+
+func encodePayload(w io.Writer, u User) (err error) {
+	// Encoding of the ID field.
+	if err = binary.Write(w, binary.BigEndian, u.ID); err != nil {
+		return err
+	}
+
+	// Encoding of the Name field.
+	if err = writeString(w, u.Name); err != nil {
 		return err
 	}
 
 	// Encoding of the Active and Age fields.
 	var activeAndAge uint64
-	if u.ActiveIndex > 0 {
+	if u.Active {
 		activeAndAge = ActiveMask
 	}
 	activeAndAge |= uint64(u.Age)
@@ -58,61 +266,224 @@ func EncodeUser(w io.Writer, u User) (err error) {
 	}
 
 	// Encoding of the Books field.
-	books := strings.Join(u.Books, ",")
-	booksLen := uint8(len(books))
-	if err = binary.Write(w, binary.BigEndian, booksLen); err != nil {
+	if err = writeUvarint(w, uint64(len(u.Books))); err != nil {
 		return err
 	}
-	if err = binary.Write(w, binary.BigEndian, []byte(books)); err != nil {
-		return err
+	for _, book := range u.Books {
+		if err = writeString(w, book); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// writeString writes the length-prefixed string s.
+func writeString(w io.Writer, s string) (err error) {
+	if err = writeUvarint(w, uint64(len(s))); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, s)
+	return err
+}
+
+func writeUvarint(w io.Writer, x uint64) error {
+	_, err := w.Write(binary.AppendUvarint(nil, x))
+	return err
+}
+
+// Decode reads the users from r. Both the current and the legacy (headerless) data are accepted.
+// If some records are damaged, Decode returns the rest of them and a *CorruptionError.
 func Decode(r io.Reader) (out []User, err error) {
-	for err != io.EOF && len(out) < MaxNumOfUsers {
-		var nameLength uint8
-		if err = binary.Read(r, binary.BigEndian, &nameLength); err != nil {
-			break
+	_, out, err = DecodeVersioned(r)
+	return out, err
+}
+
+// DecodeVersioned is like Decode, but also returns the header of the decoded data.
+func DecodeVersioned(r io.Reader) (h Header, out []User, err error) {
+	h, out, _, err = decode(r)
+	return h, out, err
+}
+
+// DecodeNextID is like Decode, but also returns the ID for the next new user.
+// It is never below the one after the greatest ID of the decoded users.
+func DecodeNextID(r io.Reader) (out []User, nextID uint64, err error) {
+	_, out, nextID, err = decode(r)
+	return out, nextID, err
+}
+
+func decode(r io.Reader) (h Header, out []User, nextID uint64, err error) {
+	rb := bufio.NewReader(r)
+	if h, err = ReadHeader(rb); err != nil {
+		return h, nil, 0, err
+	}
+	offset := int64(0)
+	if h.Version != LegacyVersion {
+		offset = int64(headerSize)
+	}
+	if h.Flags&FlagNextID != 0 {
+		if err = binary.Read(rb, binary.BigEndian, &nextID); err != nil {
+			return h, nil, 0, fmt.Errorf("couldn't read next ID: %w", err)
 		}
-		name := make([]byte, nameLength)
-		if err = binary.Read(r, binary.BigEndian, &name); err != nil {
-			break
+		offset += nextIDSize
+	}
+	data, err := io.ReadAll(rb)
+	if err != nil {
+		return h, nil, 0, err
+	}
+	corruption := new(CorruptionError)
+	if h.Flags&FlagChecksum != 0 {
+		out = decodeFrames(data, offset, h.Flags, corruption)
+	} else {
+		out = decodePayloads(data, offset, h.Flags, corruption)
+	}
+
+	nextID = max(nextID, Slice(out).NextID())
+	if len(corruption.Damaged) > 0 {
+		return h, out, nextID, corruption
+	}
+	return h, out, nextID, nil
+}
+
+// decodeFrames decodes the checksummed records of data. A damaged record is skipped
+// byte by byte until the next valid record is found.
+func decodeFrames(data []byte, offset int64, flags uint16, corruption *CorruptionError) (out []User) {
+	for pos := 0; pos < len(data); {
+		payload, n, ok := ReadFrame(data[pos:])
+		if ok {
+			u, err := decodePayload(bytes.NewReader(payload), flags)
+			if err == nil {
+				if flags&FlagUserID == 0 {
+					u.ID = uint64(len(out) + 1)
+				}
+				out = append(out, u)
+				pos += n
+				continue
+			}
 		}
-		var activeAndAge uint64
-		if err = binary.Read(r, binary.BigEndian, &activeAndAge); err != nil {
+		corruption.add(offset+int64(pos), 1)
+		pos++
+	}
+	return out
+}
+
+// ReadFrame returns the payload of the frame at the start of data and the size
+// of the whole frame if the frame is complete and its checksum matches.
+func ReadFrame(data []byte) (payload []byte, n int, ok bool) {
+	if len(data) < frameHeadSize+frameTailSize {
+		return nil, 0, false
+	}
+	length := binary.BigEndian.Uint32(data)
+	if length > MaxRecordSize || int(length) > len(data)-frameHeadSize-frameTailSize {
+		return nil, 0, false
+	}
+	payload = data[frameHeadSize : frameHeadSize+length]
+	checksum := binary.BigEndian.Uint32(data[frameHeadSize+length:])
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, 0, false
+	}
+	return payload, frameHeadSize + int(length) + frameTailSize, true
+}
+
+// decodePayloads decodes the records of the legacy data. Such records can't be
+// resynchronized, so the data after the first damaged record is lost.
+func decodePayloads(data []byte, offset int64, flags uint16, corruption *CorruptionError) (out []User) {
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		pos := len(data) - r.Len()
+		u, err := decodePayload(r, flags)
+		if err != nil {
+			corruption.add(offset+int64(pos), int64(len(data)-pos))
 			break
 		}
-		var active uint8
-		if activeAndAge&ActiveMask > 0 {
-			active = 1
+		if flags&FlagUserID == 0 {
+			u.ID = uint64(len(out) + 1)
 		}
-		var mass float64
-		if err = binary.Read(r, binary.BigEndian, &mass); err != nil {
-			break
+		out = append(out, u)
+	}
+	return out
+}
+
+func decodePayload(r *bytes.Reader, flags uint16) (user User, err error) {
+	if flags&FlagUserID != 0 {
+		if err = binary.Read(r, binary.BigEndian, &user.ID); err != nil {
+			return user, err
 		}
-		var booksLen uint8
-		if err = binary.Read(r, binary.BigEndian, &booksLen); err != nil {
-			break
+	}
+	if user.Name, err = readString(r, flags); err != nil {
+		return user, err
+	}
+	var activeAndAge uint64
+	if err = binary.Read(r, binary.BigEndian, &activeAndAge); err != nil {
+		return user, err
+	}
+	var mass float64
+	if err = binary.Read(r, binary.BigEndian, &mass); err != nil {
+		return user, err
+	}
+	if user.Books, err = readBooks(r, flags); err != nil {
+		return user, err
+	}
+
+	user.Active = activeAndAge&ActiveMask > 0
+	user.Age = uint8(activeAndAge & AgeMask)
+	user.Mass = VerifyMass(mass)
+	return user, nil
+}
+
+// readString reads a length-prefixed string.
+func readString(r *bytes.Reader, flags uint16) (string, error) {
+	var length uint64
+	if flags&FlagVarint != 0 {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return "", err
 		}
-		books := make([]byte, booksLen)
-		if err = binary.Read(r, binary.BigEndian, &books); err != nil {
-			break
+		length = n
+	} else {
+		n, err := r.ReadByte()
+		if err != nil {
+			return "", err
 		}
+		length = uint64(n)
+	}
+	if length > uint64(r.Len()) {
+		return "", ErrInvalidLength
+	}
 
-		var user User
-		user.Name = string(name)
-		user.ActiveIndex = active << len(out)
-		user.Age = uint8(activeAndAge & AgeMask)
-		user.Mass = VerifyMass(mass)
-		user.Books = strings.Split(string(books), ",")
-		out = append(out, user)
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
 	}
-	if len(out) != MaxNumOfUsers && err != io.EOF {
+	return string(b), nil
+}
+
+// readBooks reads the list of books.
+func readBooks(r *bytes.Reader, flags uint16) (books []string, err error) {
+	if flags&FlagVarint == 0 {
+		joined, err := readString(r, flags)
+		if err != nil || joined == "" {
+			return nil, err
+		}
+		return strings.Split(joined, ","), nil
+	}
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
 		return nil, err
 	}
-	return out, nil
+	// Each book takes one byte at least.
+	if count > uint64(r.Len()) {
+		return nil, ErrInvalidLength
+	}
+	for i := uint64(0); i < count; i++ {
+		book, err := readString(r, flags)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	return books, nil
 }
 
 func VerifyMass(m float64) float64 {
@@ -0,0 +1,262 @@
+package user
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	users := []User{
+		{1, "John Doe", 30, true, 80.0, []string{"Harry Potter", "1984"}},
+		{5, "Jake Doe", 20, false, 60.0, nil},
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, users); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), Magic[:]) {
+		t.Fatalf("Encode() data doesn't start with the magic bytes: %q", buf.Bytes())
+	}
+
+	h, got, err := DecodeVersioned(&buf)
+	if err != nil {
+		t.Fatalf("DecodeVersioned() error = %v", err)
+	}
+	if !h.IsCurrent() {
+		t.Errorf("DecodeVersioned() header = %+v, want %+v", h, CurrentHeader)
+	}
+	if !reflect.DeepEqual(got, users) {
+		t.Errorf("DecodeVersioned() = %+v, want %+v", got, users)
+	}
+}
+
+func TestEncodeNextID(t *testing.T) {
+	users := []User{{ID: 2, Name: "John Doe", Books: []string{"Dune"}}}
+	tests := []struct {
+		name   string
+		nextID uint64
+		want   uint64
+	}{
+		{"Kept", 7, 7},
+		{"Below the users", 1, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := EncodeNextID(&buf, users, tt.nextID); err != nil {
+				t.Fatalf("EncodeNextID() error = %v", err)
+			}
+			got, nextID, err := DecodeNextID(&buf)
+			if err != nil {
+				t.Fatalf("DecodeNextID() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, users) || nextID != tt.want {
+				t.Errorf("DecodeNextID() = %+v, %d, want %+v, %d", got, nextID, users, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecode_Legacy(t *testing.T) {
+	f, err := os.Open("../../datafiles/original.database")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	h, users, err := DecodeVersioned(f)
+	if err != nil {
+		t.Fatalf("DecodeVersioned() error = %v", err)
+	}
+	if h.Version != LegacyVersion {
+		t.Errorf("DecodeVersioned() version = %d, want %d", h.Version, LegacyVersion)
+	}
+	if len(users) != 6 {
+		t.Fatalf("DecodeVersioned() decoded %d users, want 6", len(users))
+	}
+	want := User{1, "John Doe", 30, true, 80.0, []string{"Harry Potter", "1984"}}
+	if !reflect.DeepEqual(users[0], want) {
+		t.Errorf("DecodeVersioned() first user = %+v, want %+v", users[0], want)
+	}
+	for i, u := range users {
+		if u.ID != uint64(i+1) {
+			t.Errorf("DecodeVersioned() user #%d ID = %d, want %d", i, u.ID, i+1)
+		}
+	}
+	if n := Slice(users).NumOfActiveUsers(); n != 5 {
+		t.Errorf("NumOfActiveUsers() = %d, want 5", n)
+	}
+
+	// Encoding migrates the data to the current version.
+	var buf bytes.Buffer
+	if err = Encode(&buf, users); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	h, migrated, err := DecodeVersioned(&buf)
+	if err != nil {
+		t.Fatalf("DecodeVersioned() error = %v", err)
+	}
+	if !h.IsCurrent() {
+		t.Errorf("DecodeVersioned() header = %+v, want %+v", h, CurrentHeader)
+	}
+	if !reflect.DeepEqual(migrated, users) {
+		t.Errorf("DecodeVersioned() = %+v, want %+v", migrated, users)
+	}
+}
+
+func TestDecode_ManyUsers(t *testing.T) {
+	var users []User
+	for i := 1; i <= 1000; i++ {
+		users = append(users, User{ID: uint64(i), Name: "User", Age: uint8(i), Active: i%2 == 0})
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, users); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	got, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, users) {
+		t.Errorf("Decode() decoded %d users, want %d", len(got), len(users))
+	}
+	if n := Slice(got).NumOfActiveUsers(); n != 500 {
+		t.Errorf("NumOfActiveUsers() = %d, want 500", n)
+	}
+}
+
+func TestEncodeDecode_LongValues(t *testing.T) {
+	var books []string
+	for i := 0; i < 300; i++ {
+		books = append(books, "Book")
+	}
+	tests := []struct {
+		name string
+		user User
+	}{
+		{name: "Long name", user: User{ID: 1, Name: strings.Repeat("N", 300)}},
+		{name: "Many books", user: User{ID: 2, Name: "Reader", Books: books}},
+		{name: "Long title", user: User{ID: 3, Name: "Reader", Books: []string{strings.Repeat("T", 70000)}}},
+		{name: "Commas", user: User{ID: 4, Name: "Reader", Books: []string{"Eats, Shoots & Leaves", ""}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Encode(&buf, []User{tt.user}); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			got, err := Decode(&buf)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, []User{tt.user}) {
+				t.Errorf("Decode() = %.80v, want %.80v", got, tt.user)
+			}
+		})
+	}
+}
+
+func TestEncodeUser_TooLarge(t *testing.T) {
+	u := User{ID: 1, Name: strings.Repeat("N", MaxRecordSize)}
+
+	var buf bytes.Buffer
+	if err := EncodeUser(&buf, u); !errors.Is(err, ErrRecordTooLarge) {
+		t.Errorf("EncodeUser() error = %v, want %v", err, ErrRecordTooLarge)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("EncodeUser() wrote %d bytes, want 0", buf.Len())
+	}
+}
+
+func TestReadHeader_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  Header
+		wantErr error
+	}{
+		{name: "Future version", header: Header{Version: FormatVersion + 1}, wantErr: ErrUnsupportedVersion},
+		{name: "Zero version", header: Header{Version: LegacyVersion}, wantErr: ErrUnsupportedVersion},
+		{name: "Unknown flags", header: Header{Version: FormatVersion, Flags: 0x8000}, wantErr: ErrUnknownFlags},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteHeader(&buf, tt.header); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Decode(&buf); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecode_Corruption(t *testing.T) {
+	users := []User{
+		{1, "John Doe", 30, true, 80.0, []string{"Harry Potter", "1984"}},
+		{2, "Jake Doe", 20, false, 60.0, nil},
+		{3, "Jane Doe", 40, true, 70.0, []string{"Dune"}},
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, users); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// Offsets of the records.
+	var offsets []int64
+	offset := int64(headerSize + nextIDSize)
+	for _, u := range users {
+		offsets = append(offsets, offset)
+		var rec bytes.Buffer
+		if err := EncodeUser(&rec, u); err != nil {
+			t.Fatal(err)
+		}
+		offset += int64(rec.Len())
+	}
+
+	tests := []struct {
+		name        string
+		data        []byte
+		wantUsers   []User
+		wantDamaged []Damage
+	}{
+		{
+			name: "Flipped byte",
+			data: func() []byte {
+				d := bytes.Clone(data)
+				d[offsets[1]+6] ^= 0xff
+				return d
+			}(),
+			wantUsers:   []User{users[0], users[2]},
+			wantDamaged: []Damage{{Offset: offsets[1], Length: offsets[2] - offsets[1]}},
+		},
+		{
+			name:        "Truncated",
+			data:        data[:len(data)-3],
+			wantUsers:   users[:2],
+			wantDamaged: []Damage{{Offset: offsets[2], Length: int64(len(data)) - 3 - offsets[2]}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode(bytes.NewReader(tt.data))
+			var corruption *CorruptionError
+			if !errors.As(err, &corruption) {
+				t.Fatalf("Decode() error = %v, want *CorruptionError", err)
+			}
+			if !reflect.DeepEqual(corruption.Damaged, tt.wantDamaged) {
+				t.Errorf("Decode() damaged = %+v, want %+v", corruption.Damaged, tt.wantDamaged)
+			}
+			if !reflect.DeepEqual(got, tt.wantUsers) {
+				t.Errorf("Decode() = %+v, want %+v", got, tt.wantUsers)
+			}
+		})
+	}
+}
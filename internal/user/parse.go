@@ -0,0 +1,98 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The parsers of the fields entered as text, like in the prompt or in an import file.
+// The spaces around the text are ignored.
+
+// ParseAge parses the age: a number from 0 to 255. The empty text is 0.
+func ParseAge(s string) (uint8, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	age, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid age %q, want a number from 0 to 255", s)
+	}
+	return uint8(age), nil
+}
+
+// ParseYesNo parses the answer: yes, y or true, or no, n or false in any case.
+// The empty text is no.
+func ParseYesNo(s string) (bool, error) {
+	switch s = strings.TrimSpace(s); strings.ToUpper(s) {
+	case "YES", "Y", "TRUE":
+		return true, nil
+	case "NO", "N", "FALSE", "":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid answer %q, want yes or no", s)
+}
+
+// ParseActive parses the active status like ParseYesNo.
+func ParseActive(s string) (bool, error) {
+	active, err := ParseYesNo(s)
+	if err != nil {
+		return false, fmt.Errorf("invalid active status %q, want yes or no", strings.TrimSpace(s))
+	}
+	return active, nil
+}
+
+// ParseMass parses the mass in kilograms. The negative mass is 0, and the mass
+// that looks like quintals or ounces is converted by VerifyMass.
+func ParseMass(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	mass, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mass %q", s)
+	}
+	return VerifyMass(max(mass, 0)), nil
+}
+
+var (
+	ErrUnterminatedQuote = errors.New("unterminated quote")
+	ErrInvalidQuoted     = errors.New("invalid quoted value")
+)
+
+// CutQuoted returns the first field of the line, separated by spaces, and the rest
+// of it. A field may have quoted parts like `name="Jane Doe"`; the spaces in the
+// quotes don't end the field. The spaces before the field are skipped.
+func CutQuoted(line string) (field, rest string, err error) {
+	line = strings.TrimLeftFunc(line, unicode.IsSpace)
+	quoted, escaped := false, false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case !quoted && unicode.IsSpace(r):
+			return line[:i], line[i:], nil
+		}
+	}
+	if quoted {
+		return "", "", fmt.Errorf("%w: %s", ErrUnterminatedQuote, line)
+	}
+	return line, "", nil
+}
+
+// Unquote returns the value without the quotes, if it is quoted like a Go string.
+func Unquote(value string) (string, error) {
+	if !strings.HasPrefix(value, `"`) {
+		return value, nil
+	}
+	s, err := strconv.Unquote(value)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidQuoted, value)
+	}
+	return s, nil
+}
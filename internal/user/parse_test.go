@@ -0,0 +1,106 @@
+package user
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint8
+		wantErr bool
+	}{
+		{"31", 31, false},
+		{" 255 ", 255, false},
+		{"", 0, false},
+		{"256", 0, true},
+		{"-1", 0, true},
+		{"old", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAge(tt.in)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseAge(%q) = %d, %v, want %d, error %t", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseActive(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    bool
+		wantErr bool
+	}{
+		{"yes", true, false},
+		{"Y", true, false},
+		{"true", true, false},
+		{"No", false, false},
+		{"", false, false},
+		{"maybe", false, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseActive(tt.in)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseActive(%q) = %t, %v, want %t, error %t", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseMass(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{"62.5", 62.5, false},
+		{"-3", 0, false},
+		{"0.7", VerifyMass(0.7), false},
+		{"2000", VerifyMass(2000), false},
+		{"", 0, true},
+		{"heavy", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMass(tt.in)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseMass(%q) = %g, %v, want %g, error %t", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCutQuoted(t *testing.T) {
+	tests := []struct {
+		line        string
+		field, rest string
+		wantErr     error
+	}{
+		{"  age>30 name=Ann", "age>30", " name=Ann", nil},
+		{`name="Jane Doe" age=31`, `name="Jane Doe"`, " age=31", nil},
+		{`book~"say \"hi\" now" x`, `book~"say \"hi\" now"`, " x", nil},
+		{"   ", "", "", nil},
+		{`name="Jane`, "", "", ErrUnterminatedQuote},
+	}
+	for _, tt := range tests {
+		field, rest, err := CutQuoted(tt.line)
+		if field != tt.field || rest != tt.rest || !errors.Is(err, tt.wantErr) {
+			t.Errorf("CutQuoted(%q) = %q, %q, %v, want %q, %q, %v", tt.line, field, rest, err, tt.field, tt.rest, tt.wantErr)
+		}
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	tests := []struct {
+		in, want string
+		wantErr  error
+	}{
+		{"Ann", "Ann", nil},
+		{`"Jane Doe"`, "Jane Doe", nil},
+		{`"Jane`, "", ErrInvalidQuoted},
+	}
+	for _, tt := range tests {
+		got, err := Unquote(tt.in)
+		if got != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("Unquote(%q) = %q, %v, want %q, %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
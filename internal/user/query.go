@@ -0,0 +1,188 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidQuery is wrapped by the errors of ParseQuery.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Predicate reports whether the user matches a condition.
+type Predicate func(u User) bool
+
+// Match returns the users matching the predicate in the same order.
+func (u Slice) Match(p Predicate) Slice {
+	var res Slice
+	for _, user := range u {
+		if p(user) {
+			res = append(res, user)
+		}
+	}
+	return res
+}
+
+// And returns the predicate that holds if all the predicates hold.
+func And(ps ...Predicate) Predicate {
+	return func(u User) bool {
+		for _, p := range ps {
+			if !p(u) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// ParseQuery parses the query: the conditions separated by spaces, all of which
+// must hold, like `age>30 active=yes book~"Potter"`. A condition is a field,
+// an operator and a value without spaces around the operator:
+//
+//	name, book  = != ~ !~  (~ means "contains"; the case is ignored)
+//	age, mass   = != < <= > >=
+//	active      = !=       (yes or no)
+//
+// The condition on the book holds if any of the books matches it, and the negated
+// one holds if none of them does. The value with spaces is quoted like a Go string.
+// A word without an operator is the same as name~word. The empty query matches all the users.
+func ParseQuery(q string) (Predicate, error) {
+	conds, err := splitQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	ps := make([]Predicate, 0, len(conds))
+	for _, cond := range conds {
+		p, err := parseCondition(cond)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrInvalidQuery, cond, err)
+		}
+		ps = append(ps, p)
+	}
+	return And(ps...), nil
+}
+
+// splitQuery splits the query by the spaces out of the quotes.
+func splitQuery(q string) (conds []string, err error) {
+	for {
+		cond, rest, err := CutQuoted(q)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidQuery, err)
+		}
+		if cond == "" {
+			return conds, nil
+		}
+		conds = append(conds, cond)
+		q = rest
+	}
+}
+
+// operators are the operators of the conditions; the longer ones go first.
+var operators = []string{">=", "<=", "!=", "!~", "=", "~", "<", ">"}
+
+func parseCondition(cond string) (Predicate, error) {
+	i := strings.IndexAny(cond, "=~<>!")
+	if i < 0 || strings.HasPrefix(cond, `"`) {
+		name, err := Unquote(cond)
+		if err != nil {
+			return nil, err
+		}
+		return textPredicate(func(u User) []string { return []string{u.Name} }, "~", name)
+	}
+	field := strings.ToLower(cond[:i])
+	var op string
+	for _, o := range operators {
+		if strings.HasPrefix(cond[i:], o) {
+			op = o
+			break
+		}
+	}
+	if op == "" {
+		return nil, errors.New("unknown operator")
+	}
+	value, err := Unquote(cond[i+len(op):])
+	if err != nil {
+		return nil, err
+	}
+
+	switch field {
+	case "name":
+		return textPredicate(func(u User) []string { return []string{u.Name} }, op, value)
+	case "book":
+		return textPredicate(func(u User) []string { return u.Books }, op, value)
+	case "age":
+		age, err := ParseAge(value)
+		if err != nil {
+			return nil, err
+		}
+		return numberPredicate(func(u User) float64 { return float64(u.Age) }, op, float64(age))
+	case "mass":
+		// The mass is a bound in kilograms, so unlike ParseMass it isn't converted:
+		// mass>700 must not mean more than 700 ounces.
+		mass, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mass %q", value)
+		}
+		return numberPredicate(func(u User) float64 { return u.Mass }, op, mass)
+	case "active":
+		active, err := ParseActive(value)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "=":
+			return func(u User) bool { return u.Active == active }, nil
+		case "!=":
+			return func(u User) bool { return u.Active != active }, nil
+		}
+		return nil, fmt.Errorf("operator %s doesn't apply to active", op)
+	}
+	return nil, fmt.Errorf("unknown field %q", field)
+}
+
+// textPredicate returns the predicate on the texts of the user. It holds if any of
+// the texts matches the value; the negated one holds if none of them does.
+func textPredicate(texts func(User) []string, op, value string) (Predicate, error) {
+	var match func(string) bool
+	switch op {
+	case "=", "!=":
+		match = func(s string) bool { return strings.EqualFold(s, value) }
+	case "~", "!~":
+		value := strings.ToLower(value)
+		match = func(s string) bool { return strings.Contains(strings.ToLower(s), value) }
+	default:
+		return nil, fmt.Errorf("operator %s doesn't apply to text", op)
+	}
+	negated := strings.HasPrefix(op, "!")
+	return func(u User) bool {
+		for _, s := range texts(u) {
+			if match(s) {
+				return !negated
+			}
+		}
+		return negated
+	}, nil
+}
+
+// numberPredicate returns the predicate comparing the number of the user to the value.
+func numberPredicate(number func(User) float64, op string, value float64) (Predicate, error) {
+	var cmp func(x float64) bool
+	switch op {
+	case "=":
+		cmp = func(x float64) bool { return x == value }
+	case "!=":
+		cmp = func(x float64) bool { return x != value }
+	case "<":
+		cmp = func(x float64) bool { return x < value }
+	case "<=":
+		cmp = func(x float64) bool { return x <= value }
+	case ">":
+		cmp = func(x float64) bool { return x > value }
+	case ">=":
+		cmp = func(x float64) bool { return x >= value }
+	default:
+		return nil, fmt.Errorf("operator %s doesn't apply to number", op)
+	}
+	return func(u User) bool { return cmp(number(u)) }, nil
+}
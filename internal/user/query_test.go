@@ -0,0 +1,77 @@
+package user
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	users := Slice{
+		{ID: 1, Name: "Ann", Age: 25, Active: true, Mass: 55, Books: []string{"Harry Potter", "Dune"}},
+		{ID: 2, Name: "Bob", Age: 40, Active: false, Mass: 90.5, Books: []string{"Dune"}},
+		{ID: 3, Name: "Anna Maria", Age: 31, Active: true, Mass: 62},
+		{ID: 4, Name: `Jo "J" Doe`, Age: 31},
+	}
+
+	tests := []struct {
+		query string
+		want  []uint64 // the IDs of the matching users
+	}{
+		{"", []uint64{1, 2, 3, 4}},
+		{"age>30", []uint64{2, 3, 4}},
+		{"age>=31 age<=31", []uint64{3, 4}},
+		{"age!=31", []uint64{1, 2}},
+		{"mass<60", []uint64{1, 4}},
+		{"mass=90.5", []uint64{2}},
+		{"active=yes", []uint64{1, 3}},
+		{"active!=Y", []uint64{2, 4}},
+		{"ACTIVE=no age<40", []uint64{4}},
+		{"name=ann", []uint64{1}},
+		{"name~ann", []uint64{1, 3}},
+		{"name!~ann", []uint64{2, 4}},
+		{"ann", []uint64{1, 3}},
+		{`"anna maria"`, []uint64{3}},
+		{`name="Jo \"J\" Doe"`, []uint64{4}},
+		{`book~"potter"`, []uint64{1}},
+		{"book=dune", []uint64{1, 2}},
+		{"book!=dune", []uint64{3, 4}},
+		{`book!~"harry potter" active=yes`, []uint64{3}},
+		{"  age>30   active=yes  ", []uint64{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			p, err := ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []uint64
+			for _, u := range users.Match(p) {
+				got = append(got, u.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQuery_Invalid(t *testing.T) {
+	for _, query := range []string{
+		"height>2",
+		"age>old",
+		"age>300",
+		"age~3",
+		"mass>heavy",
+		"active=maybe",
+		"active>yes",
+		"name<b",
+		"name!b",
+		`book~"Potter`,
+		`book~"\z"`,
+	} {
+		if _, err := ParseQuery(query); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("ParseQuery(%q) error = %v, want %v", query, err, ErrInvalidQuery)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+var (
+	// ErrInvalidOrder is wrapped by the errors of ParseOrder.
+	ErrInvalidOrder = errors.New("invalid order")
+	// ErrInvalidPage is wrapped by the errors of ParsePage.
+	ErrInvalidPage = errors.New("invalid page")
+)
+
+// The fields the users may be sorted by.
+const (
+	SortByID     = "id"
+	SortByName   = "name"
+	SortByAge    = "age"
+	SortByActive = "active"
+	SortByMass   = "mass"
+	// SortByBooksAvgAge sorts by the sum of the average age of the readers
+	// of the books of the user, as SortUsersBySumOfAvgAge does.
+	SortByBooksAvgAge = "books-avg-age"
+)
+
+// SortKey is a key of the order of the users.
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// Order is the order of the users by several keys: the users equal by the first
+// key are ordered by the second one and so on.
+type Order []SortKey
+
+// ParseOrder parses the order: the keys separated by commas, like "age desc, name".
+// A key is a field, optionally followed by asc or desc; "-age" is the same as "age desc".
+func ParseOrder(s string) (Order, error) {
+	var o Order
+	for _, key := range strings.Split(s, ",") {
+		words := strings.Fields(strings.ToLower(key))
+		if len(words) == 0 || len(words) > 2 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidOrder, strings.TrimSpace(key))
+		}
+		k := SortKey{Field: strings.TrimPrefix(words[0], "-"), Desc: strings.HasPrefix(words[0], "-")}
+		if len(words) == 2 {
+			switch words[1] {
+			case "asc":
+			case "desc":
+				k.Desc = !k.Desc
+			default:
+				return nil, fmt.Errorf("%w: %q: the direction must be asc or desc", ErrInvalidOrder, strings.TrimSpace(key))
+			}
+		}
+		switch k.Field {
+		case SortByID, SortByName, SortByAge, SortByActive, SortByMass, SortByBooksAvgAge:
+		default:
+			return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidOrder, k.Field)
+		}
+		o = append(o, k)
+	}
+	return o, nil
+}
+
+// Sort sorts the users in the order. The users equal by all the keys keep their
+// order. The average ages of SortByBooksAvgAge are the ones of the users being
+// sorted. Unknown fields are ignored.
+func (u Slice) Sort(o Order) {
+	var ages map[string]int
+	cmps := make([]func(a, b User) int, len(o))
+	for i, k := range o {
+		if k.Field == SortByBooksAvgAge && ages == nil {
+			ages = bookAges(AvgAgeOfReadersPerBook(u))
+		}
+		cmps[i] = compareBy(k.Field, ages)
+		if k.Desc {
+			cmp := cmps[i]
+			cmps[i] = func(a, b User) int { return cmp(b, a) }
+		}
+	}
+	slices.SortStableFunc[User](u, func(a, b User) bool {
+		for _, cmp := range cmps {
+			if c := cmp(a, b); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+// Page is the part of the listed users: Limit users after the first Offset ones.
+// The negative Limit means all the users after the Offset.
+type Page struct {
+	Offset, Limit int
+}
+
+// ParsePage parses the offset and the limit: non-negative numbers.
+// The empty offset is 0, and the empty limit means no limit.
+func ParsePage(offset, limit string) (p Page, err error) {
+	if p.Offset, err = parseCount("offset", offset, 0); err != nil {
+		return p, err
+	}
+	p.Limit, err = parseCount("limit", limit, -1)
+	return p, err
+}
+
+// parseCount parses the non-negative number; the empty text is the value of empty.
+func parseCount(name, s string, empty int) (int, error) {
+	if s = strings.TrimSpace(s); s == "" {
+		return empty, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w: %s must be a non-negative number: %q", ErrInvalidPage, name, s)
+	}
+	return n, nil
+}
+
+// List returns the users sorted in the order, matching the predicate, on the page.
+// The users are sorted before they are matched, so the average ages of
+// SortByBooksAvgAge are the ones of all the users. Nil predicate matches all
+// the users. The users are sorted in place, and the result may share the array with them.
+func (u Slice) List(o Order, p Predicate, page Page) Slice {
+	if len(o) > 0 {
+		u.Sort(o)
+	}
+	if p != nil {
+		u = u.Match(p)
+	}
+	u = u[min(page.Offset, len(u)):]
+	if page.Limit >= 0 {
+		u = u[:min(page.Limit, len(u))]
+	}
+	return u
+}
+
+// compareBy returns the function comparing the users by the field.
+// The ages are the average ages of the readers per book.
+func compareBy(field string, ages map[string]int) func(a, b User) int {
+	switch field {
+	case SortByID:
+		return func(a, b User) int { return compare(a.ID, b.ID) }
+	case SortByName:
+		return func(a, b User) int { return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)) }
+	case SortByAge:
+		return func(a, b User) int { return compare(a.Age, b.Age) }
+	case SortByActive:
+		return func(a, b User) int { return compare(boolToInt(a.Active), boolToInt(b.Active)) }
+	case SortByMass:
+		return func(a, b User) int { return compare(a.Mass, b.Mass) }
+	case SortByBooksAvgAge:
+		return func(a, b User) int { return compare(sumOfAvgAge(a, ages), sumOfAvgAge(b, ages)) }
+	}
+	return func(a, b User) int { return 0 }
+}
+
+func compare[T uint8 | uint64 | int | float64](x, y T) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// bookAges returns the average ages by the book titles.
+func bookAges(books []AvgAgePerBook) map[string]int {
+	ages := make(map[string]int, len(books))
+	for _, book := range books {
+		ages[book.BookTitle] = book.AvgAge
+	}
+	return ages
+}
+
+// sumOfAvgAge returns the sum of the average ages of the books of the user.
+func sumOfAvgAge(u User, ages map[string]int) (sum int) {
+	for _, book := range u.Books {
+		sum += ages[book]
+	}
+	return sum
+}
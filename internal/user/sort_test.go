@@ -0,0 +1,123 @@
+package user
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSlice_Sort(t *testing.T) {
+	users := Slice{
+		{ID: 1, Name: "Ann", Age: 25, Active: true, Mass: 55, Books: []string{"Dune"}},
+		{ID: 2, Name: "Bob", Age: 40, Mass: 90, Books: []string{"Dune", "Emma"}},
+		{ID: 3, Name: "carl", Age: 31, Active: true, Mass: 62},
+		{ID: 4, Name: "Dan", Age: 31, Mass: 55, Books: []string{"Emma"}},
+	}
+
+	tests := []struct {
+		order string
+		want  []uint64 // the IDs of the sorted users
+	}{
+		{"age", []uint64{1, 3, 4, 2}},
+		{"age desc", []uint64{2, 3, 4, 1}},
+		{"-age, name desc", []uint64{2, 4, 3, 1}},
+		{"mass,id desc", []uint64{4, 1, 3, 2}},
+		{"active desc, age", []uint64{1, 3, 4, 2}},
+		{"name", []uint64{1, 2, 3, 4}},
+		{"ID DESC", []uint64{4, 3, 2, 1}},
+		// The sums of the average ages are 33, 69, 0 and 36.
+		{"books-avg-age", []uint64{3, 1, 4, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			o, err := ParseOrder(tt.order)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sorted := append(Slice(nil), users...)
+			sorted.Sort(o)
+			var got []uint64
+			for _, u := range sorted {
+				got = append(got, u.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Sort() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOrder_Invalid(t *testing.T) {
+	for _, order := range []string{
+		"",
+		"age,",
+		"height",
+		"age up",
+		"age desc asc",
+		"-",
+	} {
+		if _, err := ParseOrder(order); !errors.Is(err, ErrInvalidOrder) {
+			t.Errorf("ParseOrder(%q) error = %v, want %v", order, err, ErrInvalidOrder)
+		}
+	}
+}
+
+func TestSlice_List(t *testing.T) {
+	users := Slice{
+		{ID: 1, Name: "Ann", Age: 25, Active: true},
+		{ID: 2, Name: "Bob", Age: 40},
+		{ID: 3, Name: "Carl", Age: 31, Active: true},
+		{ID: 4, Name: "Dan", Age: 35, Active: true},
+	}
+	active := func(u User) bool { return u.Active }
+
+	tests := []struct {
+		name   string
+		order  string
+		filter Predicate
+		offset string
+		limit  string
+		want   []uint64 // the IDs of the listed users
+	}{
+		{"all", "", nil, "", "", []uint64{1, 2, 3, 4}},
+		{"sorted", "-age", nil, "", "", []uint64{2, 4, 3, 1}},
+		{"filtered page", "age", active, "1", "1", []uint64{3}},
+		{"zero limit", "", nil, "", "0", nil},
+		{"offset past end", "", nil, "5", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var o Order
+			if tt.order != "" {
+				var err error
+				if o, err = ParseOrder(tt.order); err != nil {
+					t.Fatal(err)
+				}
+			}
+			page, err := ParsePage(tt.offset, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []uint64
+			for _, u := range append(Slice(nil), users...).List(o, tt.filter, page) {
+				got = append(got, u.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("List() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePage_Invalid(t *testing.T) {
+	for _, tt := range []struct{ offset, limit string }{
+		{"-1", ""},
+		{"", "-1"},
+		{"one", ""},
+		{"", "1.5"},
+	} {
+		if _, err := ParsePage(tt.offset, tt.limit); !errors.Is(err, ErrInvalidPage) {
+			t.Errorf("ParsePage(%q, %q) error = %v, want %v", tt.offset, tt.limit, err, ErrInvalidPage)
+		}
+	}
+}
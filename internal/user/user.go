@@ -14,11 +14,13 @@ const massPrecision = 3
 var Headers = []string{"Name", "Age", "Active", "Mass", "Books"}
 
 type User struct {
-	Name        string
-	Age         uint8
-	ActiveIndex uint8
-	Mass        float64
-	Books       []string
+	// ID identifies the user; it doesn't change during the user's lifetime.
+	ID     uint64   `json:"id"`
+	Name   string   `json:"name"`
+	Age    uint8    `json:"age"`
+	Active bool     `json:"active"`
+	Mass   float64  `json:"mass"`
+	Books  []string `json:"books"`
 }
 
 type Name string
@@ -33,10 +35,10 @@ func (a Age) String() string {
 	return fmt.Sprintf("%d", uint8(a))
 }
 
-type ActiveIndex uint8
+type Active bool
 
-func (a ActiveIndex) String() string {
-	if a > 0 {
+func (a Active) String() string {
+	if a {
 		return "yes"
 	}
 	return "-"
@@ -75,7 +77,7 @@ func (users Slice) NewTable(headers []string) (res table.Table) {
 		row := make(table.Row)
 		row[res.Headers[0]] = Name(user.Name).String()
 		row[res.Headers[1]] = Age(user.Age).String()
-		row[res.Headers[2]] = ActiveIndex(user.ActiveIndex).String()
+		row[res.Headers[2]] = Active(user.Active).String()
 		row[res.Headers[3]] = Mass(user.Mass).String()
 		row[res.Headers[4]] = Books(user.Books).String()
 
@@ -110,9 +112,19 @@ func (u Slice) FindName(name string) (int, bool) {
 	return i, ok
 }
 
+// NextID returns an ID for a new user: the one next to the greatest ID in use.
+func (u Slice) NextID() (id uint64) {
+	for _, user := range u {
+		if user.ID > id {
+			id = user.ID
+		}
+	}
+	return id + 1
+}
+
 func (u Slice) NumOfActiveUsers() (n int) {
 	for _, user := range u {
-		if user.ActiveIndex > 0 {
+		if user.Active {
 			n++
 		}
 	}
@@ -121,20 +133,10 @@ func (u Slice) NumOfActiveUsers() (n int) {
 
 // Sorts the users by the sum of the average age for each book they read.
 // Used in the 3rd practice.
+// See also SortByBooksAvgAge.
 func SortUsersBySumOfAvgAge(users []User, books []AvgAgePerBook) {
-	ages := make(map[string]int)
-	for _, book := range books {
-		ages[book.BookTitle] = book.AvgAge
-	}
-
+	ages := bookAges(books)
 	slices.SortFunc[User](users, func(x, y User) bool {
-		var sumX, sumY int
-		for _, book := range x.Books {
-			sumX += ages[book]
-		}
-		for _, book := range y.Books {
-			sumY += ages[book]
-		}
-		return sumX < sumY
+		return sumOfAvgAge(x, ages) < sumOfAvgAge(y, ages)
 	})
 }
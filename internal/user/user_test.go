@@ -53,15 +53,11 @@ func TestAge_String(t *testing.T) {
 func TestActive_String(t *testing.T) {
 	tests := []struct {
 		name string
-		a    ActiveIndex
+		a    Active
 		want string
 	}{
-		{name: "True 0", a: 0b0001, want: "yes"},
-		{name: "True 1", a: 0b0010, want: "yes"},
-		{name: "True 2", a: 0b0100, want: "yes"},
-		{name: "True 3", a: 0b1000, want: "yes"},
-		{name: "True 7", a: 0b10000000, want: "yes"},
-		{name: "False", a: 0b0, want: "-"},
+		{name: "True", a: true, want: "yes"},
+		{name: "False", a: false, want: "-"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -131,8 +127,8 @@ func TestSlice_NewTable(t *testing.T) {
 		{
 			name: "Test",
 			users: Slice{
-				{"John Doe", 30, 0b00000001, 80.0, []string{"Harry Potter", "1984"}},
-				{"Jake Doe", 20, 0b0, 60.0, []string{}},
+				{1, "John Doe", 30, true, 80.0, []string{"Harry Potter", "1984"}},
+				{2, "Jake Doe", 20, false, 60.0, []string{}},
 			},
 			args: args{
 				headers: []string{"Name", "Age", "Active", "Mass", "Books"},
@@ -1,49 +1,345 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"practice/internal/auth"
+	"practice/internal/httpapi"
 	"practice/internal/storage"
 	"practice/internal/tcp"
+	"practice/internal/tui"
 	"practice/internal/user"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	modeLocal  = "local"
+	modeServer = "server"
+	modeClient = "client"
+	modeHTTP   = "http"
+)
+
+// The exit statuses.
+const (
+	exitOK      = 0
+	exitFailure = 1 // the storage or the server failed
+	exitUsage   = 2 // the flags are invalid
+	exitAborted = 3 // the sessions didn't end in time on shutdown
+	exitScript  = 4 // a command of the script failed
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "passwd" {
+		os.Exit(runPasswd(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(runImport(os.Args[2:]))
+	}
+	os.Exit(run())
+}
+
+func run() (status int) {
+	dbPath := flag.String("db", "datafiles/test.database", "path to the database `file`")
+	addr := flag.String("addr", ":8000", "`address` to listen on (server) or to connect to (client)")
+	mode := flag.String("mode", modeLocal, "mode: local (text interface), server, client or http (JSON API)")
+	readOnly := flag.Bool("readonly", false, "open the database for reading only")
+	maxConns := flag.Int("maxconns", 100, "maximum `number` of simultaneous connections to the server")
+	drainTimeout := flag.Duration("drain", 10*time.Second, "how long the server waits for the active sessions on shutdown")
+	idleTimeout := flag.Duration("idle", 5*time.Minute, "how long the server waits for the next command of a session; 0 means forever")
+	cmdTimeout := flag.Duration("cmd-timeout", time.Minute, "how long a command of a session may take, with its prompts; 0 means forever")
+	maxLine := flag.Int("max-line", 64<<10, "maximum length of the input lines of a session in `bytes`; 0 means no limit")
+	undoWindow := flag.Duration("undo-window", 5*time.Minute, "how long the server keeps the undo history of a session for reconnecting; 0 means it ends with the session")
+	rate := flag.Float64("rate", 0, "maximum `number` of the commands per second from an IP address; 0 means no limit")
+	backups := flag.Int("backups", 5, "`number` of the previous snapshots kept as backups")
+	syncFlag := flag.String("sync", "always", "commit the changes to the disk: always, never or every `interval` (e.g. 100ms)")
+	usersFile := flag.String("users", "", "credentials `file` of the users that log in to the TCP server (see the passwd command)")
+	tlsCert := flag.String("tls-cert", "", "certificate `file` (PEM) of the server; enables TLS with -tls-key")
+	tlsKey := flag.String("tls-key", "", "private key `file` (PEM) of the server")
+	tlsCA := flag.String("tls-ca", "", "CA certificate `file` (PEM) the client verifies the server with; enables TLS")
+	useTLS := flag.Bool("tls", false, "connect to the server with TLS verified by the system CA certificates")
+	script := flag.String("script", "", "run the commands of the `file` instead of the prompt; - means the standard input")
+	keepGoing := flag.Bool("continue", false, "go on with the script after a failed command")
+	flag.Usage = usage
+	flag.Parse()
+	// The database file may be also given as the argument: app [FILE].
+	if flag.NArg() > 0 {
+		*dbPath = flag.Arg(0)
+	}
+
+	if *maxConns < 1 {
+		return usageError("The number of connections must be positive.")
+	}
+	if *idleTimeout < 0 || *cmdTimeout < 0 || *maxLine < 0 || *rate < 0 || *undoWindow < 0 {
+		return usageError("The timeouts and the limits can't be negative.")
+	}
+	if *script != "" && *mode != modeLocal {
+		return usageError("The script runs in the local mode only.")
+	}
+	// Only the TCP server has the login; the HTTP API would ignore the users and accept any write.
+	if *usersFile != "" && *mode != modeServer {
+		return usageError("The users log in to the TCP server only.")
+	}
+	policy, err := storage.ParseSyncPolicy(*syncFlag)
+	if err != nil {
+		return usageError(err)
+	}
+
+	// The first SIGINT or SIGTERM shuts the application down gracefully,
+	// the second one kills it.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	var tcpOpts []tcp.Option
+	switch *mode {
+	case modeLocal:
+	case modeServer, modeHTTP:
+		if (*tlsCert == "") != (*tlsKey == "") {
+			return usageError("Both -tls-cert and -tls-key are needed for TLS.")
+		}
+		if *tlsCert != "" {
+			cfg, err := tcp.LoadServerTLS(*tlsCert, *tlsKey)
+			if err != nil {
+				log.Println(err)
+				return exitFailure
+			}
+			tcpOpts = append(tcpOpts, tcp.WithTLS(cfg))
+		}
+		if *usersFile != "" {
+			users, err := auth.LoadFile(*usersFile)
+			if err != nil {
+				log.Println(err)
+				return exitFailure
+			}
+			tcpOpts = append(tcpOpts, tcp.WithUsers(users))
+		}
+	case modeClient:
+		if *useTLS || *tlsCA != "" {
+			cfg, err := tcp.LoadClientTLS(*tlsCA)
+			if err != nil {
+				log.Println(err)
+				return exitFailure
+			}
+			tcpOpts = append(tcpOpts, tcp.WithTLS(cfg))
+		}
+		// The client doesn't need the storage.
+		err = tcp.Client(ctx, *addr, os.Stdin, os.Stdout, tcpOpts...)
+		if err != nil && ctx.Err() == nil {
+			log.Println("client:", err)
+			return exitFailure
+		}
+		return exitOK
+	default:
+		return usageError(fmt.Sprintf("Unknown mode %q.", *mode))
+	}
+
 	// Open/create a storage.
-	strg, err := storage.NewStorage()
+	strg, err := storage.NewStorage(
+		storage.WithPath(*dbPath),
+		storage.WithReadOnly(*readOnly),
+		storage.WithSync(policy),
+		storage.WithBackups(*backups),
+	)
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		return exitFailure
 	}
-	defer closeStorage(strg)
+	defer func() {
+		if !closeStorage(strg) && status == exitOK {
+			status = exitFailure
+		}
+	}()
 
 	// Read the data from the storage.
-	users, err := user.Decode(strg.Reader())
-	if err != nil {
-		log.Fatal(err)
+	err = strg.Load()
+	var corruption *user.CorruptionError
+	switch {
+	case errors.As(err, &corruption):
+		log.Println("Warning: damaged records are skipped:", err)
+	case err != nil:
+		log.Println(err)
+		return exitFailure
+	}
+	if !strg.ReadOnly() {
+		defer func() {
+			if !saveSnapshot(strg) && status == exitOK {
+				status = exitFailure
+			}
+		}()
+	}
+
+	switch *mode {
+	case modeLocal:
+		if *script != "" {
+			return runScript(ctx, strg, *script, *keepGoing)
+		}
+		return runLocal(ctx, strg, *dbPath+".history")
+	case modeHTTP:
+		return runHTTP(ctx, *addr, strg, *drainTimeout, *tlsCert, *tlsKey)
 	}
-	defer saveSnapshot(strg, &users)
 
-	c := make(chan int)
 	// Start a TCP server.
-	go tcp.Server(c, strg, &users)
-	// Start a TCP client.
-	go tcp.Client(c)
+	tcpOpts = append(tcpOpts, tcp.WithMaxConns(*maxConns), tcp.WithDrainTimeout(*drainTimeout),
+		tcp.WithIdleTimeout(*idleTimeout), tcp.WithCommandTimeout(*cmdTimeout), tcp.WithMaxLineLength(*maxLine),
+		tcp.WithUndoWindow(*undoWindow))
+	if *rate > 0 {
+		// A client may send a second's worth of the commands at once.
+		tcpOpts = append(tcpOpts, tcp.WithRateLimit(*rate, int(math.Ceil(*rate))))
+	}
+	err = tcp.Server(ctx, *addr, strg, tcpOpts...)
+	switch {
+	case errors.Is(err, tcp.ErrSessionsAborted):
+		log.Println("tcp server:", err)
+		return exitAborted
+	case err != nil:
+		log.Println("tcp server:", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// runLocal shows the text user interface prompt until the session ends or ctx is canceled.
+// On a terminal, the lines are edited with the history kept in the file.
+func runLocal(ctx context.Context, strg *storage.Storage, historyFile string) int {
+	var opts []tui.Option
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		e, err := tui.NewEditor(os.Stdin, os.Stdout, historyFile)
+		if err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+		// The editor may be reading the line when ctx is canceled.
+		defer e.Close()
+		opts = append(opts, tui.WithEditor(e))
+	}
+	ended := make(chan error, 1)
+	go func() {
+		ended <- tui.Prompt(os.Stdout, os.Stdin, strg, auth.RoleWrite, opts...)
+	}()
+
+	select {
+	case err := <-ended:
+		if err != nil && err != tui.ErrEndOfSession {
+			log.Println(err)
+			return exitFailure
+		}
+	case <-ctx.Done():
+		// The prompt is abandoned: it is blocked on reading the input.
+		fmt.Println()
+	}
+	return exitOK
+}
+
+// runScript runs the commands of the file until the script ends or ctx is canceled.
+// The file "-" is the standard input.
+func runScript(ctx context.Context, strg *storage.Storage, path string, keepGoing bool) int {
+	r := io.Reader(os.Stdin)
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+		defer f.Close()
+		r = f
+	}
+	var opts []tui.Option
+	if keepGoing {
+		opts = append(opts, tui.WithContinueOnError())
+	}
+	ended := make(chan error, 1)
+	go func() {
+		ended <- tui.RunScript(os.Stdout, r, strg, auth.RoleWrite, opts...)
+	}()
+
+	select {
+	case err := <-ended:
+		switch {
+		case errors.Is(err, tui.ErrScriptFailed):
+			log.Println(err)
+			return exitScript
+		case err != nil:
+			log.Println(err)
+			return exitFailure
+		}
+	case <-ctx.Done():
+		log.Println("The script is interrupted.")
+		return exitScript
+	}
+	return exitOK
+}
+
+// runHTTP serves the JSON API until ctx is canceled. If the certificate is given,
+// the API is served over HTTPS.
+func runHTTP(ctx context.Context, addr string, strg *storage.Storage, drainTimeout time.Duration, certFile, keyFile string) int {
+	srv := &http.Server{Addr: addr, Handler: httpapi.NewHandler(strg), ReadHeaderTimeout: 10 * time.Second}
+	failed := make(chan error, 1)
+	go func() {
+		if certFile != "" {
+			failed <- srv.ListenAndServeTLS(certFile, keyFile)
+			return
+		}
+		failed <- srv.ListenAndServe()
+	}()
+	log.Println("Serving the HTTP API on", addr)
+
+	select {
+	case err := <-failed:
+		log.Println("http server:", err)
+		return exitFailure
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("http server:", err)
+		return exitAborted
+	}
+	return exitOK
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [FILE]\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s passwd [flags] NAME [read|write]\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s import [flags] FILE\n", os.Args[0])
+	flag.PrintDefaults()
+}
 
-	<-c
-	<-c
-	// Show the text user interface prompt.
-	// tui.Prompt(os.Stdin, os.Stdout, strg, &users)
+// usageError prints the error with the usage and returns the exit status.
+func usageError(err any) int {
+	fmt.Fprintln(os.Stderr, err)
+	flag.Usage()
+	return exitUsage
 }
 
-func closeStorage(strg *storage.Storage) {
+func closeStorage(strg *storage.Storage) bool {
 	if err := strg.Close(); err != nil {
-		log.Fatal("closeStorage: ", err)
+		log.Println("closeStorage:", err)
+		return false
 	}
 	log.Println("Done. Bye.")
+	return true
 }
 
-func saveSnapshot(strg *storage.Storage, users *[]user.User) {
+func saveSnapshot(strg *storage.Storage) bool {
 	log.Print("Saving snapshot... ")
-	if err := strg.SaveSnapshot(users); err != nil {
-		log.Fatal("saveSnapshot: ", err)
+	if err := strg.SaveSnapshot(); err != nil {
+		log.Println("saveSnapshot:", err)
+		return false
 	}
+	return true
 }
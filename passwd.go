@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"practice/internal/auth"
+	"strings"
+)
+
+// credentialsPerms are the permissions of the credentials file.
+const credentialsPerms = 0600 // rw-------
+
+// runPasswd runs the passwd command that sets the password and the role of the user
+// in the credentials file. The password is read from the standard input.
+func runPasswd(args []string) int {
+	flags := flag.NewFlagSet("passwd", flag.ContinueOnError)
+	usersFile := flags.String("users", "datafiles/users", "credentials `file`")
+	remove := flags.Bool("delete", false, "delete the user")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s passwd [flags] NAME [read|write]\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Sets the password (read from the standard input) and the role of the user.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if n := flags.NArg(); n < 1 || n > 2 || *remove && n != 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	name := flags.Arg(0)
+	role := auth.RoleWrite
+	if flags.NArg() == 2 {
+		var err error
+		if role, err = auth.ParseRole(flags.Arg(1)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			flags.Usage()
+			return exitUsage
+		}
+	}
+
+	users, err := loadCredentials(*usersFile)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	if *remove {
+		if !users.Delete(name) {
+			log.Printf("User %q is not found.", name)
+			return exitFailure
+		}
+	} else {
+		fmt.Fprint(os.Stderr, "Password: ")
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && password == "" {
+			log.Println("couldn't read password:", err)
+			return exitFailure
+		}
+		password = strings.TrimRight(password, "\r\n")
+		if password == "" {
+			log.Println("The password can't be empty.")
+			return exitFailure
+		}
+		if err = users.Set(name, password, role); err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+	}
+	if err = users.SaveFile(*usersFile, credentialsPerms); err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// loadCredentials reads the credentials file, or returns an empty store if it doesn't exist.
+func loadCredentials(path string) (*auth.Store, error) {
+	users, err := auth.LoadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return auth.NewStore(), nil
+	}
+	return users, err
+}